@@ -1,11 +1,13 @@
 package auth
 
 import (
+	"crypto"
 	"crypto/ecdsa"
-	"crypto/rand"
 	"crypto/sha256"
+	"encoding/asn1"
 	"encoding/base64"
 	"fmt"
+	"math/big"
 	"time"
 )
 
@@ -17,6 +19,8 @@ func init() {
 	jwtHeader = base64.RawURLEncoding.EncodeToString(header)
 }
 
+// JwtToken signs deterministically (RFC 6979): the same key and claims
+// always give the same token.
 func JwtToken(signKey *ecdsa.PrivateKey, aud string, exp time.Time, subject string) (string, error) {
 	data := fmt.Sprintf(`{"aud":"%s","exp":%d,"sub":"%s"}`, aud, exp.Unix(), subject)
 
@@ -26,16 +30,21 @@ func JwtToken(signKey *ecdsa.PrivateKey, aud string, exp time.Time, subject stri
 
 	hash := sha256.Sum256([]byte(payload))
 
-	r, s, err := ecdsa.Sign(rand.Reader, signKey, hash[:])
+	der, err := signKey.Sign(nil, hash[:], crypto.SHA256)
 	if err != nil {
 		return "", err
 	}
 
-	out := make([]byte, 2*32)
-	r.FillBytes(out[0:32])
-	s.FillBytes(out[32:])
+	var sig struct {
+		R, S *big.Int
+	}
+	if _, err := asn1.Unmarshal(der, &sig); err != nil {
+		return "", err
+	}
 
-	sig := base64.RawURLEncoding.EncodeToString(out)
+	out := make([]byte, 2*32)
+	sig.R.FillBytes(out[0:32])
+	sig.S.FillBytes(out[32:])
 
-	return payload + "." + sig, nil
+	return payload + "." + base64.RawURLEncoding.EncodeToString(out), nil
 }
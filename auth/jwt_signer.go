@@ -1,88 +1,388 @@
 package auth
 
 import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"log/slog"
 	"net/url"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/Firebain/webpush-go/clock"
+	"github.com/Firebain/webpush-go/metrics"
+)
+
+const (
+	DefaultCacheExpiry        = time.Hour*12 + time.Minute*30
+	DefaultCacheRefreshAfter  = time.Minute * 20
+	DefaultCacheMaxEntries    = 1024
+	DefaultCacheSweepInterval = time.Minute * 10
+
+	// MaxCacheExpiry is the longest token lifetime RFC 8292 allows.
+	MaxCacheExpiry = time.Hour * 24
 )
 
 type WebPushJwtSigner interface {
 	VapidHeader(endpoint *url.URL, vapidPrivate, vapidPublic, subject string) (string, error)
 }
 
-type SimpleJwtSigner struct{}
+// KeyPairJwtSigner signs with an already parsed key pair. The client prefers
+// it over VapidHeader when the signer implements it.
+type KeyPairJwtSigner interface {
+	WebPushJwtSigner
+	VapidHeaderForKeyPair(endpoint *url.URL, keys *VapidKeyPair, subject string) (string, error)
+}
+
+type CacheAwareJwtSigner interface {
+	WebPushJwtSigner
+	VapidHeaderCached(endpoint *url.URL, keys *VapidKeyPair, subject string) (string, bool, error)
+}
+
+type SimpleJwtSigner struct {
+	Clock  clock.Clock
+	Logger *slog.Logger
+}
+
+func (s *SimpleJwtSigner) VapidHeader(endpoint *url.URL, vapidPrivate, vapidPublic, subject string) (string, error) {
+	keys, err := NewVapidKeyPair(vapidPrivate, vapidPublic)
+	if err != nil {
+		return "", err
+	}
+
+	return s.VapidHeaderForKeyPair(endpoint, keys, subject)
+}
 
-func (*SimpleJwtSigner) VapidHeader(endpoint *url.URL, vapidPrivate, vapidPublic, subject string) (string, error) {
+func (s *SimpleJwtSigner) VapidHeaderForKeyPair(endpoint *url.URL, keys *VapidKeyPair, subject string) (string, error) {
 	aud := endpoint.Scheme + "://" + endpoint.Host
-	exp := time.Now().Add(time.Hour * 12)
+	exp := clock.OrSystem(s.Clock).Now().Add(time.Hour * 12)
 
-	token, err := sign(vapidPrivate, vapidPublic, subject, aud, exp)
+	token, err := sign(keys, subject, aud, exp)
 	if err != nil {
 		return "", err
 	}
 
-	return authHeader(token, vapidPublic), nil
+	if s.Logger != nil {
+		s.Logger.LogAttrs(context.Background(), slog.LevelDebug, "vapid token signed",
+			slog.String("aud", aud),
+			slog.Time("exp", exp),
+		)
+	}
+
+	return authHeader(token, keys.publicKey), nil
 }
 
 type headerRecord struct {
-	header string
-	exp    time.Time
+	key     [sha256.Size]byte
+	header  string
+	refresh time.Time
+}
+
+type CacheStats struct {
+	Hits      uint64
+	Misses    uint64
+	Refreshes uint64
+	Evictions uint64
+	Entries   int
+}
+
+type CachedJwtSignerOption func(*CachedJwtSigner)
+
+func WithCacheExpiry(expiry time.Duration) CachedJwtSignerOption {
+	return func(c *CachedJwtSigner) {
+		c.expiry = expiry
+	}
+}
+
+func WithCacheRefreshAfter(refreshAfter time.Duration) CachedJwtSignerOption {
+	return func(c *CachedJwtSigner) {
+		c.refreshAfter = refreshAfter
+	}
+}
+
+func WithCacheMaxEntries(maxEntries int) CachedJwtSignerOption {
+	return func(c *CachedJwtSigner) {
+		c.maxEntries = maxEntries
+	}
+}
+
+func WithCacheClock(clk clock.Clock) CachedJwtSignerOption {
+	return func(c *CachedJwtSigner) {
+		c.clock = clk
+	}
+}
+
+func WithCacheLogger(logger *slog.Logger) CachedJwtSignerOption {
+	return func(c *CachedJwtSigner) {
+		c.logger = logger
+	}
+}
+
+func WithCacheMetrics(recorder metrics.Recorder) CachedJwtSignerOption {
+	return func(c *CachedJwtSigner) {
+		c.metrics = recorder
+	}
+}
+
+// WithCacheSweepInterval also sweeps from a background goroutine, which
+// Close stops. Without it stale entries are swept on access.
+func WithCacheSweepInterval(interval time.Duration) CachedJwtSignerOption {
+	return func(c *CachedJwtSigner) {
+		c.sweepInterval = interval
+		c.sweepBackground = interval > 0
+	}
 }
 
 type CachedJwtSigner struct {
-	headers *sync.Map
+	expiry          time.Duration
+	refreshAfter    time.Duration
+	maxEntries      int
+	sweepInterval   time.Duration
+	sweepBackground bool
+	clock           clock.Clock
+	logger          *slog.Logger
+	metrics         metrics.Recorder
+
+	mu        sync.Mutex
+	lru       *list.List
+	records   map[[sha256.Size]byte]*list.Element
+	lastSweep time.Time
+
+	hits      atomic.Uint64
+	misses    atomic.Uint64
+	refreshes atomic.Uint64
+	evictions atomic.Uint64
+
+	stop      chan struct{}
+	closeOnce sync.Once
 }
 
-func NewCachedJwtSigner() *CachedJwtSigner {
-	return &CachedJwtSigner{
-		headers: &sync.Map{},
+// NewCachedJwtSigner caps the expiry at MaxCacheExpiry and falls back to
+// half the expiry when refreshAfter isn't positive or would outlive the token.
+func NewCachedJwtSigner(opts ...CachedJwtSignerOption) *CachedJwtSigner {
+	c := &CachedJwtSigner{
+		expiry:        DefaultCacheExpiry,
+		refreshAfter:  DefaultCacheRefreshAfter,
+		maxEntries:    DefaultCacheMaxEntries,
+		sweepInterval: DefaultCacheSweepInterval,
+		clock:         clock.System{},
+		lru:           list.New(),
+		records:       make(map[[sha256.Size]byte]*list.Element),
+		stop:          make(chan struct{}),
 	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	if c.expiry <= 0 {
+		c.expiry = DefaultCacheExpiry
+	}
+
+	if c.expiry > MaxCacheExpiry {
+		c.expiry = MaxCacheExpiry
+	}
+
+	if c.refreshAfter <= 0 || c.refreshAfter >= c.expiry {
+		c.refreshAfter = c.expiry / 2
+	}
+
+	if c.sweepInterval <= 0 {
+		c.sweepInterval = DefaultCacheSweepInterval
+	}
+
+	c.lastSweep = c.clock.Now()
+
+	if c.sweepBackground {
+		go c.sweepLoop()
+	}
+
+	return c
 }
 
 func (c *CachedJwtSigner) VapidHeader(endpoint *url.URL, vapidPrivate, vapidPublic, subject string) (string, error) {
+	keys, err := NewVapidKeyPair(vapidPrivate, vapidPublic)
+	if err != nil {
+		return "", err
+	}
+
+	return c.VapidHeaderForKeyPair(endpoint, keys, subject)
+}
+
+func (c *CachedJwtSigner) VapidHeaderForKeyPair(endpoint *url.URL, keys *VapidKeyPair, subject string) (string, error) {
+	header, _, err := c.VapidHeaderCached(endpoint, keys, subject)
+
+	return header, err
+}
+
+func (c *CachedJwtSigner) VapidHeaderCached(endpoint *url.URL, keys *VapidKeyPair, subject string) (string, bool, error) {
+	if keys == nil || keys.privateKey == nil {
+		return "", false, errEmptyKeyPair
+	}
+
 	aud := endpoint.Scheme + "://" + endpoint.Host
 
-	key := vapidPrivate + vapidPublic + aud + subject
+	key := sha256.Sum256([]byte(keys.publicKey + "\x00" + aud + "\x00" + subject))
+
+	now := c.clock.Now()
 
-	record, ok := c.headers.Load(key)
+	c.mu.Lock()
+	if now.Sub(c.lastSweep) >= c.sweepInterval {
+		c.sweepLocked(now)
+	}
+
+	elem, ok := c.records[key]
 	if ok {
-		hRecord := record.(*headerRecord)
+		record := elem.Value.(*headerRecord)
+		if now.Before(record.refresh) {
+			c.lru.MoveToFront(elem)
+			c.mu.Unlock()
+			c.hits.Add(1)
+			c.record(aud, "hit")
 
-		invDate := time.Now().Add(time.Hour*12 + time.Minute*10)
-		if hRecord.exp.After(invDate) {
-			return hRecord.header, nil
+			return record.header, true, nil
 		}
 	}
+	c.mu.Unlock()
 
-	exp := time.Now().Add(time.Hour*12 + time.Minute*30)
+	reason := "miss"
+	if ok {
+		reason = "refresh"
+		c.refreshes.Add(1)
+	} else {
+		c.misses.Add(1)
+	}
+	c.record(aud, reason)
+
+	exp := now.Add(c.expiry)
 
-	token, err := sign(vapidPrivate, vapidPublic, subject, aud, exp)
+	token, err := sign(keys, subject, aud, exp)
 	if err != nil {
-		return "", err
+		return "", false, err
 	}
 
-	header := authHeader(token, vapidPublic)
+	if c.logger != nil {
+		c.logger.LogAttrs(context.Background(), slog.LevelDebug, "vapid header cache refresh",
+			slog.String("aud", aud),
+			slog.String("reason", reason),
+			slog.Time("exp", exp),
+		)
+	}
 
-	c.headers.Store(key, &headerRecord{
-		header: header,
-		exp:    exp,
+	header := authHeader(token, keys.publicKey)
+
+	c.store(&headerRecord{
+		key:     key,
+		header:  header,
+		refresh: now.Add(c.refreshAfter),
 	})
 
-	return header, nil
+	return header, false, nil
 }
 
-func sign(vapidPrivate, vapidPublic, subject string, aud string, exp time.Time) (string, error) {
-	vapidSignature, err := DecodeVapidKeys(vapidPrivate, vapidPublic)
-	if err != nil {
-		return "", err
+func (c *CachedJwtSigner) record(aud string, result string) {
+	if c.metrics == nil {
+		return
+	}
+
+	c.metrics.Counter("webpush_jwt_cache_lookups_total", metrics.Labels{
+		"origin": aud,
+		"result": result,
+	}, 1)
+}
+
+func (c *CachedJwtSigner) Stats() CacheStats {
+	c.mu.Lock()
+	entries := c.lru.Len()
+	c.mu.Unlock()
+
+	return CacheStats{
+		Hits:      c.hits.Load(),
+		Misses:    c.misses.Load(),
+		Refreshes: c.refreshes.Load(),
+		Evictions: c.evictions.Load(),
+		Entries:   entries,
+	}
+}
+
+func (c *CachedJwtSigner) Close() {
+	c.closeOnce.Do(func() {
+		close(c.stop)
+	})
+}
+
+func (c *CachedJwtSigner) store(record *headerRecord) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.records[record.key]; ok {
+		elem.Value = record
+		c.lru.MoveToFront(elem)
+
+		return
+	}
+
+	c.records[record.key] = c.lru.PushFront(record)
+
+	for c.maxEntries > 0 && c.lru.Len() > c.maxEntries {
+		c.remove(c.lru.Back())
+		c.evictions.Add(1)
+	}
+}
+
+func (c *CachedJwtSigner) remove(elem *list.Element) {
+	c.lru.Remove(elem)
+	delete(c.records, elem.Value.(*headerRecord).key)
+}
+
+func (c *CachedJwtSigner) sweep(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.sweepLocked(now)
+}
+
+func (c *CachedJwtSigner) sweepLocked(now time.Time) {
+	c.lastSweep = now
+
+	for elem := c.lru.Back(); elem != nil; {
+		prev := elem.Prev()
+		if !now.Before(elem.Value.(*headerRecord).refresh) {
+			c.remove(elem)
+		}
+		elem = prev
+	}
+}
+
+func (c *CachedJwtSigner) sweepLoop() {
+	ticker := time.NewTicker(c.sweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.sweep(c.clock.Now())
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+var errEmptyKeyPair = errors.New("vapid key pair is empty")
+
+func sign(keys *VapidKeyPair, subject string, aud string, exp time.Time) (string, error) {
+	if keys == nil || keys.privateKey == nil {
+		return "", errEmptyKeyPair
 	}
 
 	if !strings.HasPrefix(subject, "mailto:") {
 		subject = "mailto:" + subject
 	}
 
-	token, err := JwtToken(vapidSignature, aud, exp, subject)
+	token, err := JwtToken(keys.privateKey, aud, exp, subject)
 	if err != nil {
 		return "", err
 	}
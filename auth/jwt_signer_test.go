@@ -0,0 +1,134 @@
+package auth
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/Firebain/webpush-go/clock"
+)
+
+func testKeyPair(t *testing.T) *VapidKeyPair {
+	pair, err := NewVapidKeyPair(
+		"F4uhvy_ej2DySTchnmJSpra62xFUK5KrMkWaOPB5VgU",
+		"BAHN13txEjbVBbZik4WjbNB7eGgLybxTUiIpBdMfAGvdOO9lv4hxq_ZjdJZxvmUdsUQNV-V2eKkFHOQ_uhDrGXI",
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pair
+}
+
+func TestCachedJwtSigner(t *testing.T) {
+	pair := testKeyPair(t)
+
+	endpoints := []*url.URL{
+		{Scheme: "https", Host: "a.push.com"},
+		{Scheme: "https", Host: "b.push.com"},
+		{Scheme: "https", Host: "c.push.com"},
+	}
+
+	t.Run("Hits and misses", func(t *testing.T) {
+		signer := NewCachedJwtSigner()
+		defer signer.Close()
+
+		first, err := signer.VapidHeaderForKeyPair(endpoints[0], pair, "example@push.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		second, err := signer.VapidHeaderForKeyPair(endpoints[0], pair, "example@push.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if first != second {
+			t.Fatal("Cached header was not reused")
+		}
+
+		stats := signer.Stats()
+		if stats.Hits != 1 || stats.Misses != 1 || stats.Entries != 1 {
+			t.Fatalf("Unexpected stats %+v", stats)
+		}
+	})
+
+	t.Run("Refresh after window", func(t *testing.T) {
+		fake := clock.NewFake(time.Unix(1710588595, 0))
+		signer := NewCachedJwtSigner(WithCacheClock(fake), WithCacheRefreshAfter(time.Minute))
+		defer signer.Close()
+
+		for _, step := range []time.Duration{0, 30 * time.Second, 30 * time.Second} {
+			fake.Advance(step)
+
+			if _, err := signer.VapidHeaderForKeyPair(endpoints[0], pair, "example@push.com"); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		stats := signer.Stats()
+		if stats.Misses != 1 || stats.Hits != 1 || stats.Refreshes != 1 {
+			t.Fatalf("Unexpected stats %+v", stats)
+		}
+	})
+
+	t.Run("LRU eviction", func(t *testing.T) {
+		signer := NewCachedJwtSigner(WithCacheMaxEntries(2))
+		defer signer.Close()
+
+		for _, endpoint := range []*url.URL{endpoints[0], endpoints[1], endpoints[0], endpoints[2], endpoints[0]} {
+			if _, err := signer.VapidHeaderForKeyPair(endpoint, pair, "example@push.com"); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		stats := signer.Stats()
+		if stats.Entries != 2 || stats.Evictions != 1 || stats.Hits != 2 {
+			t.Fatalf("Unexpected stats %+v", stats)
+		}
+	})
+
+	t.Run("Sweep stale entries", func(t *testing.T) {
+		signer := NewCachedJwtSigner()
+		defer signer.Close()
+
+		if _, err := signer.VapidHeaderForKeyPair(endpoints[0], pair, "example@push.com"); err != nil {
+			t.Fatal(err)
+		}
+
+		signer.sweep(time.Now().Add(DefaultCacheRefreshAfter))
+
+		if stats := signer.Stats(); stats.Entries != 0 {
+			t.Fatalf("Unexpected stats %+v", stats)
+		}
+	})
+
+	t.Run("Sweep on access", func(t *testing.T) {
+		fake := clock.NewFake(time.Unix(1710588595, 0))
+		signer := NewCachedJwtSigner(WithCacheClock(fake))
+		defer signer.Close()
+
+		if _, err := signer.VapidHeaderForKeyPair(endpoints[0], pair, "example@push.com"); err != nil {
+			t.Fatal(err)
+		}
+
+		fake.Advance(DefaultCacheRefreshAfter)
+
+		if _, err := signer.VapidHeaderForKeyPair(endpoints[1], pair, "example@push.com"); err != nil {
+			t.Fatal(err)
+		}
+
+		if stats := signer.Stats(); stats.Entries != 1 {
+			t.Fatalf("Unexpected stats %+v", stats)
+		}
+	})
+
+	t.Run("Clamp lifetimes", func(t *testing.T) {
+		signer := NewCachedJwtSigner(WithCacheExpiry(48*time.Hour), WithCacheRefreshAfter(36*time.Hour))
+		defer signer.Close()
+
+		if signer.expiry != MaxCacheExpiry || signer.refreshAfter != MaxCacheExpiry/2 {
+			t.Fatal("Unexpected lifetimes", signer.expiry, signer.refreshAfter)
+		}
+	})
+}
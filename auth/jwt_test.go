@@ -1,7 +1,11 @@
 package auth
 
 import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"encoding/base64"
 	"fmt"
+	"math/big"
 	"strings"
 	"testing"
 	"time"
@@ -33,3 +37,45 @@ func TestJwtToken(t *testing.T) {
 		t.Fatal("Jwt token doesn't match")
 	}
 }
+
+func TestJwtTokenDeterministic(t *testing.T) {
+	signKey, err := DecodeVapidKeys(
+		"F4uhvy_ej2DySTchnmJSpra62xFUK5KrMkWaOPB5VgU",
+		"BAHN13txEjbVBbZik4WjbNB7eGgLybxTUiIpBdMfAGvdOO9lv4hxq_ZjdJZxvmUdsUQNV-V2eKkFHOQ_uhDrGXI",
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tokens := make([]string, 2)
+	for i := range tokens {
+		tokens[i], err = JwtToken(
+			signKey,
+			"https://test-ns.com",
+			time.Unix(1710588595, 0),
+			"example@push.com",
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if tokens[0] != tokens[1] {
+		t.Fatal("Tokens differ for the same claims")
+	}
+
+	parts := strings.Split(tokens[0], ".")
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	r := new(big.Int).SetBytes(sig[:32])
+	s := new(big.Int).SetBytes(sig[32:])
+
+	if !ecdsa.Verify(&signKey.PublicKey, hash[:], r, s) {
+		t.Fatal("Signature doesn't verify")
+	}
+}
@@ -1,54 +1,110 @@
 package auth
 
 import (
+	"bytes"
+	"crypto/ecdh"
 	"crypto/ecdsa"
 	"crypto/elliptic"
+	"encoding/base64"
+	"errors"
 	"math/big"
 
-	"github.com/Firebain/webpush-go/internal/base64"
+	ibase64 "github.com/Firebain/webpush-go/internal/base64"
 )
 
-func DecodeVapidKeys(privateKey string, publicKey string) (*ecdsa.PrivateKey, error) {
-	pKeyBytes, err := base64.DecodeUrlBase64(publicKey)
+type VapidKeyPair struct {
+	privateKey *ecdsa.PrivateKey
+	publicKey  string
+}
+
+func NewVapidKeyPair(privateKey string, publicKey string) (*VapidKeyPair, error) {
+	key, err := DecodeVapidKeys(privateKey, publicKey)
 	if err != nil {
 		return nil, err
 	}
 
-	curve := elliptic.P256()
+	return &VapidKeyPair{
+		privateKey: key,
+		publicKey:  base64.RawURLEncoding.EncodeToString(elliptic.Marshal(key.Curve, key.X, key.Y)),
+	}, nil
+}
 
-	pKey := ecdsa.PublicKey{}
-	pKey.Curve = curve
-	pKey.X, pKey.Y = elliptic.Unmarshal(curve, pKeyBytes)
+func (p *VapidKeyPair) PublicKey() string {
+	return p.publicKey
+}
+
+// ECDSAPublicKey returns a copy of the public key, or nil for a zero pair.
+func (p *VapidKeyPair) ECDSAPublicKey() *ecdsa.PublicKey {
+	if p.privateKey == nil {
+		return nil
+	}
 
-	keyBytes, err := base64.DecodeUrlBase64(privateKey)
+	public := p.privateKey.PublicKey
+
+	return &public
+}
+
+func DecodeVapidKeys(privateKey string, publicKey string) (*ecdsa.PrivateKey, error) {
+	pKeyBytes, err := ibase64.DecodeUrlBase64(publicKey)
 	if err != nil {
 		return nil, err
 	}
 
-	d := new(big.Int).SetBytes(keyBytes)
+	pKey, err := ecdh.P256().NewPublicKey(pKeyBytes)
+	if err != nil {
+		return nil, errors.New("invalid vapid public key")
+	}
 
-	return &ecdsa.PrivateKey{
-		D:         d,
-		PublicKey: pKey,
-	}, nil
+	key, err := decodePrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(key.PublicKey().Bytes(), pKey.Bytes()) {
+		return nil, errors.New("vapid public key does not match private key")
+	}
+
+	return toECDSA(key), nil
 }
 
 func DecodeVapidPrivateKey(privateKey string) (*ecdsa.PrivateKey, error) {
-	keyBytes, err := base64.DecodeUrlBase64(privateKey)
+	key, err := decodePrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return toECDSA(key), nil
+}
+
+func decodePrivateKey(privateKey string) (*ecdh.PrivateKey, error) {
+	keyBytes, err := ibase64.DecodeUrlBase64(privateKey)
 	if err != nil {
 		return nil, err
 	}
 
+	if len(keyBytes) < 32 {
+		padded := make([]byte, 32)
+		copy(padded[32-len(keyBytes):], keyBytes)
+		keyBytes = padded
+	}
+
+	key, err := ecdh.P256().NewPrivateKey(keyBytes)
+	if err != nil {
+		return nil, errors.New("invalid vapid private key")
+	}
+
+	return key, nil
+}
+
+func toECDSA(key *ecdh.PrivateKey) *ecdsa.PrivateKey {
 	curve := elliptic.P256()
 
 	pKey := ecdsa.PublicKey{}
 	pKey.Curve = curve
-	pKey.X, pKey.Y = curve.ScalarBaseMult(keyBytes)
-
-	d := new(big.Int).SetBytes(keyBytes)
+	pKey.X, pKey.Y = elliptic.Unmarshal(curve, key.PublicKey().Bytes())
 
 	return &ecdsa.PrivateKey{
-		D:         d,
+		D:         new(big.Int).SetBytes(key.Bytes()),
 		PublicKey: pKey,
-	}, nil
+	}
 }
@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestNewVapidKeyPair(t *testing.T) {
+	const private = "F4uhvy_ej2DySTchnmJSpra62xFUK5KrMkWaOPB5VgU"
+	const public = "BAHN13txEjbVBbZik4WjbNB7eGgLybxTUiIpBdMfAGvdOO9lv4hxq_ZjdJZxvmUdsUQNV-V2eKkFHOQ_uhDrGXI"
+
+	t.Run("Valid pair", func(t *testing.T) {
+		pair, err := NewVapidKeyPair(private, public)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if pair.PublicKey() != public {
+			t.Fatal("Unexpected public key", pair.PublicKey())
+		}
+	})
+
+	t.Run("Point not on curve", func(t *testing.T) {
+		_, err := NewVapidKeyPair(private, "BAHN13txEjbVBbZik4WjbNB7eGgLybxTUiIpBdMfAGvdOO9lv4hxq_ZjdJZxvmUdsUQNV-V2eKkFHOQ_uhDrGXA")
+		if err == nil {
+			t.Fatal("Invalid point was accepted")
+		}
+	})
+
+	t.Run("Mismatched pair", func(t *testing.T) {
+		_, err := NewVapidKeyPair(
+			"BdqJiVn-wHy0Jsr8kJ9kAceyuihPf31RiBP7SWtG5eU",
+			public,
+		)
+		if err == nil {
+			t.Fatal("Mismatched pair was accepted")
+		}
+	})
+
+	t.Run("Empty keys", func(t *testing.T) {
+		if _, err := NewVapidKeyPair("", ""); err == nil {
+			t.Fatal("Empty keys were accepted")
+		}
+	})
+
+	t.Run("Zero pair", func(t *testing.T) {
+		endpoint := &url.URL{Scheme: "https", Host: "push.com"}
+
+		if _, err := (&SimpleJwtSigner{}).VapidHeaderForKeyPair(endpoint, &VapidKeyPair{}, "example@push.com"); err == nil {
+			t.Fatal("Zero pair was signed")
+		}
+
+		if _, err := NewCachedJwtSigner().VapidHeaderForKeyPair(endpoint, &VapidKeyPair{}, "example@push.com"); err == nil {
+			t.Fatal("Zero pair was signed")
+		}
+	})
+}
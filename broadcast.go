@@ -0,0 +1,223 @@
+package webpush
+
+import (
+	"context"
+	"sync"
+)
+
+const DefaultBroadcastWorkers = 16
+
+type BroadcastStats struct {
+	// Estimated is counted before the broadcast starts.
+	Estimated    int
+	Sent         int
+	Deduplicated int
+	Failed       int
+	Gone         int
+	Pruned       int
+	Deferred     int
+	Dropped      int
+}
+
+func (s BroadcastStats) Done() int {
+	return s.Sent + s.Deduplicated + s.Failed + s.Gone + s.Deferred + s.Dropped
+}
+
+type Broadcaster struct {
+	client *WebPushClient
+	store  SubscriptionStore
+
+	workers    int
+	vapid      VapidDetails
+	tenantID   string
+	prune      bool
+	onProgress func(stats BroadcastStats)
+}
+
+type BroadcastOption func(*Broadcaster)
+
+func WithBroadcastWorkers(workers int) BroadcastOption {
+	return func(b *Broadcaster) {
+		b.workers = workers
+	}
+}
+
+func WithBroadcastVapidDetails(details VapidDetails) BroadcastOption {
+	return func(b *Broadcaster) {
+		b.vapid = details
+	}
+}
+
+func WithBroadcastTenant(tenantID string) BroadcastOption {
+	return func(b *Broadcaster) {
+		b.tenantID = tenantID
+	}
+}
+
+func WithBroadcastPruneGone() BroadcastOption {
+	return func(b *Broadcaster) {
+		b.prune = true
+	}
+}
+
+// WithBroadcastProgress serializes calls to fn.
+func WithBroadcastProgress(fn func(stats BroadcastStats)) BroadcastOption {
+	return func(b *Broadcaster) {
+		b.onProgress = fn
+	}
+}
+
+func NewBroadcaster(client *WebPushClient, store SubscriptionStore, opts ...BroadcastOption) *Broadcaster {
+	b := &Broadcaster{
+		client:  client,
+		store:   store,
+		workers: DefaultBroadcastWorkers,
+	}
+
+	for _, opt := range opts {
+		opt(b)
+	}
+
+	if b.workers < 1 {
+		b.workers = 1
+	}
+
+	return b
+}
+
+func (b *Broadcaster) Estimate(ctx context.Context, filter Filter) (int, error) {
+	count := 0
+
+	err := b.store.Range(ctx, func(rec *SubscriptionRecord) bool {
+		if matches(filter, rec) {
+			count++
+		}
+
+		return true
+	})
+
+	return count, err
+}
+
+// Broadcast counts failed sends in the stats and only returns an error when
+// the store can't be read or ctx is done.
+func (b *Broadcaster) Broadcast(ctx context.Context, filter Filter, payload []byte, options *WebPushOptions) (*BroadcastStats, error) {
+	estimated, err := b.Estimate(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		mu    sync.Mutex
+		stats = BroadcastStats{Estimated: estimated}
+		wg    sync.WaitGroup
+	)
+
+	records := make(chan *SubscriptionRecord)
+
+	for i := 0; i < b.workers; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for rec := range records {
+				outcome, pruned := b.send(ctx, rec, payload, options)
+
+				mu.Lock()
+				switch outcome {
+				case outcomeSuccess:
+					stats.Sent++
+				case outcomeDeduplicated:
+					stats.Deduplicated++
+				case outcomeGone:
+					stats.Gone++
+				case outcomeDeferred:
+					stats.Deferred++
+				case outcomeDropped:
+					stats.Dropped++
+				default:
+					stats.Failed++
+				}
+
+				if pruned {
+					stats.Pruned++
+				}
+
+				if b.onProgress != nil {
+					b.onProgress(stats)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	err = b.store.Range(ctx, func(rec *SubscriptionRecord) bool {
+		if !matches(filter, rec) {
+			return true
+		}
+
+		select {
+		case records <- rec:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	})
+
+	close(records)
+	wg.Wait()
+
+	if err == nil {
+		err = ctx.Err()
+	}
+
+	return &stats, err
+}
+
+func (b *Broadcaster) send(ctx context.Context, rec *SubscriptionRecord, payload []byte, options *WebPushOptions) (string, bool) {
+	var msgOptions *WebPushOptions
+	if options != nil {
+		copied := *options
+		msgOptions = &copied
+	}
+
+	res, err := b.client.SendWithResult(ctx, payload, &WebPushInfo{
+		Subscription: rec.Subscription,
+		VapidDetails: b.vapid,
+		TenantID:     b.tenantID,
+		Policy:       rec.Policy,
+	}, msgOptions)
+	if err != nil {
+		return outcomeError, false
+	}
+
+	if res.Deduplicated {
+		return outcomeDeduplicated, false
+	}
+
+	if res.Response == nil && res.Policy != nil {
+		if res.Policy.Action == PolicyDefer {
+			return outcomeDeferred, false
+		}
+
+		return outcomeDropped, false
+	}
+
+	if res.Response == nil {
+		return outcomeError, false
+	}
+
+	if res.Response.Body != nil {
+		res.Response.Body.Close()
+	}
+
+	outcome := statusOutcome(res.Response.StatusCode)
+	if outcome != outcomeGone || !b.prune {
+		return outcome, false
+	}
+
+	deleted, err := b.store.Delete(ctx, rec.ID)
+
+	return outcome, err == nil && deleted
+}
@@ -0,0 +1,163 @@
+package webpush
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Firebain/webpush-go/auth"
+	"github.com/Firebain/webpush-go/ece"
+)
+
+type endpointClientMock struct {
+	mu       sync.Mutex
+	Statuses map[string]int
+	Sent     []string
+}
+
+func (c *endpointClientMock) Do(req *http.Request) (*http.Response, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	endpoint := req.URL.String()
+	c.Sent = append(c.Sent, endpoint)
+
+	status, ok := c.Statuses[endpoint]
+	if !ok {
+		status = http.StatusCreated
+	}
+
+	return &http.Response{
+		StatusCode: status,
+		Body:       io.NopCloser(bytes.NewReader(nil)),
+	}, nil
+}
+
+func TestBroadcast(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemorySubscriptionStore()
+
+	for i := 0; i < 10; i++ {
+		sub := testInfo().Subscription
+		sub.Endpoint = fmt.Sprintf("https://test-ns.com/ns/token-%d", i)
+
+		rec := NewSubscriptionRecord(sub)
+		rec.Tags = Tags{"locale": {"en-US"}}
+		if i%2 == 0 {
+			rec.Tags.Set("locale", "de-DE")
+		}
+
+		if err := store.Put(ctx, rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	client := endpointClientMock{Statuses: map[string]int{
+		"https://test-ns.com/ns/token-0": http.StatusGone,
+		"https://test-ns.com/ns/token-2": http.StatusInternalServerError,
+	}}
+	webpush := NewWebPushClient(&client, &auth.SimpleJwtSigner{}, &ece.Aes128GcmEncoder{})
+
+	var progress []int
+	broadcaster := NewBroadcaster(webpush, store,
+		WithBroadcastVapidDetails(testInfo().VapidDetails),
+		WithBroadcastWorkers(3),
+		WithBroadcastPruneGone(),
+		WithBroadcastProgress(func(stats BroadcastStats) {
+			progress = append(progress, stats.Done())
+		}),
+	)
+
+	filter, err := ParseFilter(`locale = de-DE`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	estimated, err := broadcaster.Estimate(ctx, filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if estimated != 5 {
+		t.Fatal("Unexpected estimate", estimated)
+	}
+
+	stats, err := broadcaster.Broadcast(ctx, filter, []byte("Hello"), &WebPushOptions{TTL: 60})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := BroadcastStats{Estimated: 5, Sent: 3, Failed: 1, Gone: 1, Pruned: 1}
+	if *stats != expected {
+		t.Fatal("Unexpected stats", *stats)
+	}
+
+	if fmt.Sprint(progress) != "[1 2 3 4 5]" {
+		t.Fatal("Unexpected progress", progress)
+	}
+
+	for _, endpoint := range client.Sent {
+		var n int
+		fmt.Sscanf(strings.TrimPrefix(endpoint, "https://test-ns.com/ns/token-"), "%d", &n)
+		if n%2 != 0 {
+			t.Fatal("Message sent to a subscription outside the segment", endpoint)
+		}
+	}
+
+	if store.Len() != 9 {
+		t.Fatal("Gone subscription was not pruned")
+	}
+
+	t.Run("Deduplicated", func(t *testing.T) {
+		webpush := NewWebPushClient(&endpointClientMock{}, &auth.SimpleJwtSigner{}, &ece.Aes128GcmEncoder{}, WithDedupStore(NewMemoryDedupStore(0, 0)))
+		broadcaster := NewBroadcaster(webpush, store, WithBroadcastVapidDetails(testInfo().VapidDetails))
+
+		options := &WebPushOptions{TTL: 60, IdempotencyKey: "welcome"}
+		if _, err := broadcaster.Broadcast(ctx, filter, []byte("Hello"), options); err != nil {
+			t.Fatal(err)
+		}
+
+		stats, err := broadcaster.Broadcast(ctx, filter, []byte("Hello"), options)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if stats.Sent != 0 || stats.Deduplicated != 4 {
+			t.Fatal("Unexpected stats", *stats)
+		}
+	})
+
+	t.Run("Missing response", func(t *testing.T) {
+		empty := func(next SendFunc) SendFunc {
+			return func(ctx context.Context, msg *Message) (*SendResult, error) {
+				return &SendResult{}, nil
+			}
+		}
+
+		webpush := NewWebPushClient(&endpointClientMock{}, &auth.SimpleJwtSigner{}, &ece.Aes128GcmEncoder{}, WithMiddleware(empty))
+		broadcaster := NewBroadcaster(webpush, store, WithBroadcastVapidDetails(testInfo().VapidDetails))
+
+		stats, err := broadcaster.Broadcast(ctx, filter, []byte("Hello"), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if stats.Failed != 4 {
+			t.Fatal("Unexpected stats", *stats)
+		}
+	})
+
+	t.Run("Cancelled context", func(t *testing.T) {
+		cancelled, cancel := context.WithCancel(ctx)
+		cancel()
+
+		if _, err := broadcaster.Broadcast(cancelled, nil, []byte("Hello"), nil); err == nil {
+			t.Fatal("Expected an error for a cancelled context")
+		}
+	})
+}
@@ -0,0 +1,219 @@
+package webpush
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+
+	"github.com/Firebain/webpush-go/ece"
+)
+
+const MaxChunks = 64
+
+// ChunkEnvelope is the JSON object carried by every chunk of a payload split
+// with SplitPayload:
+//
+//	{"webpush_chunk":{"id":"<message id>","index":0,"count":3},"data":"<base64>"}
+//
+// All chunks of one payload share the id. A receiver collects the chunks with
+// indices 0..count-1, decodes data with standard base64, concatenates the
+// results in index order and only then parses the original payload.
+type ChunkEnvelope struct {
+	Chunk ChunkHeader `json:"webpush_chunk"`
+	Data  string      `json:"data"`
+}
+
+type ChunkHeader struct {
+	ID    string `json:"id"`
+	Index int    `json:"index"`
+	Count int    `json:"count"`
+}
+
+func SplitPayload(id string, payload []byte, padSize int) ([][]byte, error) {
+	chunkSize, err := maxChunkSize(id, padSize)
+	if err != nil {
+		return nil, err
+	}
+
+	count := (len(payload) + chunkSize - 1) / chunkSize
+	if count == 0 {
+		count = 1
+	}
+
+	if count > MaxChunks {
+		return nil, ece.ErrPayloadTooLarge
+	}
+
+	chunks := make([][]byte, 0, count)
+	for index := 0; index < count; index++ {
+		end := min((index+1)*chunkSize, len(payload))
+
+		data, err := json.Marshal(ChunkEnvelope{
+			Chunk: ChunkHeader{ID: id, Index: index, Count: count},
+			Data:  base64.StdEncoding.EncodeToString(payload[index*chunkSize : end]),
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if err := checkPayloadSize(data, padSize); err != nil {
+			return nil, err
+		}
+
+		chunks = append(chunks, data)
+	}
+
+	return chunks, nil
+}
+
+func maxChunkSize(id string, padSize int) (int, error) {
+	worst, err := json.Marshal(ChunkEnvelope{
+		Chunk: ChunkHeader{ID: id, Index: MaxChunks - 1, Count: MaxChunks},
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	budget := ece.MaxMessageSize - ece.EncryptedSize(len(worst), 0)
+	if padSize > 0 {
+		budget -= padSize
+	}
+
+	size := budget / 4 * 3
+	if size <= 0 {
+		return 0, errors.New("chunk id is too long")
+	}
+
+	return size, nil
+}
+
+func (c *WebPushClient) SendChunked(ctx context.Context, payload []byte, info *WebPushInfo, options *WebPushOptions) ([]*SendResult, error) {
+	chunkOptions := WebPushOptions{TTL: DefaultTTL}
+	if options != nil {
+		if options.Topic != "" || options.Collapsible {
+			return nil, errors.New("chunked messages can't use topics")
+		}
+
+		chunkOptions = *options
+	}
+
+	id, err := newChunkID()
+	if err != nil {
+		return nil, err
+	}
+
+	chunks, err := SplitPayload(id, payload, ece.DefaultBlockSize)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]*SendResult, 0, len(chunks))
+	for index, chunk := range chunks {
+		opts := chunkOptions
+		if options != nil && options.IdempotencyKey != "" {
+			opts.IdempotencyKey = options.IdempotencyKey + "#" + strconv.Itoa(index)
+		}
+
+		res, err := c.SendWithResult(ctx, chunk, info, &opts)
+		if err != nil {
+			return results, err
+		}
+
+		results = append(results, res)
+
+		// A policy applies to every chunk, so a deferred or dropped chunk
+		// leaves the message incomplete.
+		if res.Response == nil && !res.Deduplicated {
+			return results, res.err()
+		}
+
+		if res.Response != nil && res.Response.StatusCode >= 300 {
+			return results, errors.New("chunk delivery failed: " + res.Response.Status)
+		}
+	}
+
+	return results, nil
+}
+
+func newChunkID() (string, error) {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(buf), nil
+}
+
+type Reassembler struct {
+	mu       sync.Mutex
+	messages map[string][][]byte
+	received map[string]int
+}
+
+func NewReassembler() *Reassembler {
+	return &Reassembler{
+		messages: make(map[string][][]byte),
+		received: make(map[string]int),
+	}
+}
+
+func (r *Reassembler) Add(data []byte) ([]byte, bool, error) {
+	var envelope ChunkEnvelope
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return nil, false, err
+	}
+
+	header := envelope.Chunk
+	if header.ID == "" || header.Count <= 0 || header.Count > MaxChunks || header.Index < 0 || header.Index >= header.Count {
+		return nil, false, errors.New("invalid chunk header")
+	}
+
+	part, err := base64.StdEncoding.DecodeString(envelope.Data)
+	if err != nil {
+		return nil, false, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	parts, ok := r.messages[header.ID]
+	if !ok {
+		parts = make([][]byte, header.Count)
+		r.messages[header.ID] = parts
+	}
+
+	if len(parts) != header.Count {
+		return nil, false, errors.New("chunk count mismatch")
+	}
+
+	if parts[header.Index] == nil {
+		parts[header.Index] = part
+		r.received[header.ID]++
+	}
+
+	if r.received[header.ID] < header.Count {
+		return nil, false, nil
+	}
+
+	delete(r.messages, header.ID)
+	delete(r.received, header.ID)
+
+	var payload []byte
+	for _, part := range parts {
+		payload = append(payload, part...)
+	}
+
+	return payload, true, nil
+}
+
+func (r *Reassembler) Pending() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return len(r.messages)
+}
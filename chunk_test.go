@@ -0,0 +1,74 @@
+package webpush
+
+import (
+	"bytes"
+	"context"
+	mathrand "math/rand"
+	"testing"
+
+	"github.com/Firebain/webpush-go/auth"
+	"github.com/Firebain/webpush-go/ece"
+)
+
+func TestChunking(t *testing.T) {
+	payload := make([]byte, 10000)
+	mathrand.New(mathrand.NewSource(1)).Read(payload)
+
+	t.Run("Split and reassemble", func(t *testing.T) {
+		chunks, err := SplitPayload("message-1", payload, ece.DefaultBlockSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(chunks) < 3 {
+			t.Fatal("Unexpected number of chunks", len(chunks))
+		}
+
+		reassembler := NewReassembler()
+		for _, i := range mathrand.New(mathrand.NewSource(2)).Perm(len(chunks)) {
+			if ece.EncryptedSize(len(chunks[i]), ece.DefaultBlockSize) > ece.MaxMessageSize {
+				t.Fatal("Chunk doesn't fit", i)
+			}
+
+			out, done, err := reassembler.Add(chunks[i])
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if done {
+				if !bytes.Equal(out, payload) {
+					t.Fatal("Reassembled payload differs")
+				}
+			}
+		}
+
+		if reassembler.Pending() != 0 {
+			t.Fatal("Message was not completed")
+		}
+	})
+
+	t.Run("Send", func(t *testing.T) {
+		service := pushServiceMock{}
+		webpush := NewWebPushClient(&service, &auth.SimpleJwtSigner{}, &ece.Aes128GcmEncoder{})
+
+		results, err := webpush.SendChunked(context.Background(), payload, testInfo(), &WebPushOptions{TTL: 60, Urgency: "high"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(results) != len(service.Requests) {
+			t.Fatal("Unexpected number of requests")
+		}
+
+		for _, req := range service.Requests {
+			if req.ContentLength > ece.MaxMessageSize || req.Header.Get("TTL") != "60" || req.Header.Get("Urgency") != "high" {
+				t.Fatal("Unexpected chunk request", req.ContentLength, req.Header)
+			}
+		}
+
+		_, err = webpush.SendChunked(context.Background(), payload, testInfo(), &WebPushOptions{Topic: "news"})
+		if err == nil {
+			t.Fatal("Chunked message with topic was accepted")
+		}
+	})
+}
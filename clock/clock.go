@@ -0,0 +1,26 @@
+package clock
+
+import "time"
+
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type System struct{}
+
+func (System) Now() time.Time {
+	return time.Now()
+}
+
+func (System) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func OrSystem(c Clock) Clock {
+	if c == nil {
+		return System{}
+	}
+
+	return c
+}
@@ -0,0 +1,72 @@
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+type waiter struct {
+	at time.Time
+	ch chan time.Time
+}
+
+type Fake struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []waiter
+}
+
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now}
+}
+
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.now
+}
+
+func (f *Fake) After(d time.Duration) <-chan time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- f.now
+
+		return ch
+	}
+
+	f.waiters = append(f.waiters, waiter{at: f.now.Add(d), ch: ch})
+
+	return ch
+}
+
+func (f *Fake) Advance(d time.Duration) {
+	f.Set(f.Now().Add(d))
+}
+
+func (f *Fake) Set(now time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.now = now
+
+	pending := f.waiters[:0]
+	for _, w := range f.waiters {
+		if now.Before(w.at) {
+			pending = append(pending, w)
+		} else {
+			w.ch <- now
+		}
+	}
+	f.waiters = pending
+}
+
+func (f *Fake) Waiters() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return len(f.waiters)
+}
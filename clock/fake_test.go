@@ -0,0 +1,34 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFake(t *testing.T) {
+	start := time.Unix(1710588595, 0)
+	fake := NewFake(start)
+
+	ch := fake.After(time.Minute)
+
+	fake.Advance(30 * time.Second)
+	select {
+	case <-ch:
+		t.Fatal("Fired too early")
+	default:
+	}
+
+	fake.Advance(30 * time.Second)
+	select {
+	case now := <-ch:
+		if !now.Equal(start.Add(time.Minute)) {
+			t.Fatal("Unexpected time", now)
+		}
+	default:
+		t.Fatal("Did not fire")
+	}
+
+	if fake.Waiters() != 0 {
+		t.Fatal("Waiter was not removed")
+	}
+}
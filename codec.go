@@ -0,0 +1,362 @@
+package webpush
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+const sealedPrefix = "$"
+
+// SubscriptionCodec seals values as
+// "$<key id>$<base64url(nonce || ciphertext)>"; Decrypt passes other values
+// through unchanged.
+type SubscriptionCodec struct {
+	mu      sync.RWMutex
+	keys    map[string]cipher.AEAD
+	current string
+}
+
+func NewSubscriptionCodec() *SubscriptionCodec {
+	return &SubscriptionCodec{
+		keys: make(map[string]cipher.AEAD),
+	}
+}
+
+func (c *SubscriptionCodec) Add(id string, key []byte) error {
+	if !validCodecKeyID(id) {
+		return errors.New("invalid key id")
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.keys[id]; ok {
+		return errors.New("key id already exists")
+	}
+
+	c.keys[id] = aead
+
+	if c.current == "" {
+		c.current = id
+	}
+
+	return nil
+}
+
+func (c *SubscriptionCodec) Rotate(id string, key []byte) error {
+	if err := c.Add(id, key); err != nil {
+		return err
+	}
+
+	return c.SetCurrent(id)
+}
+
+func (c *SubscriptionCodec) SetCurrent(id string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.keys[id]; !ok {
+		return errors.New("unknown key id")
+	}
+
+	c.current = id
+
+	return nil
+}
+
+func (c *SubscriptionCodec) Remove(id string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if id == c.current {
+		return errors.New("cannot remove current key")
+	}
+
+	if _, ok := c.keys[id]; !ok {
+		return errors.New("unknown key id")
+	}
+
+	delete(c.keys, id)
+
+	return nil
+}
+
+func (c *SubscriptionCodec) Encrypt(recordID string, sub Subscription) (Subscription, error) {
+	c.mu.RLock()
+	id, aead := c.current, c.keys[c.current]
+	c.mu.RUnlock()
+
+	if aead == nil {
+		return Subscription{}, errors.New("codec has no keys")
+	}
+
+	origin, token, err := splitEndpoint(sub.Endpoint)
+	if err != nil {
+		return Subscription{}, err
+	}
+
+	seal := func(field string, value string) (string, error) {
+		nonce := make([]byte, aead.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return "", err
+		}
+
+		sealed := aead.Seal(nonce, nonce, []byte(value), sealedData(field, origin, recordID))
+
+		return sealedPrefix + id + sealedPrefix + base64.RawURLEncoding.EncodeToString(sealed), nil
+	}
+
+	sealedToken, err := seal("endpoint", token)
+	if err != nil {
+		return Subscription{}, err
+	}
+
+	encrypted := sub
+	encrypted.Endpoint = origin + "/" + sealedToken
+
+	if encrypted.Keys.P256DH, err = seal("p256dh", sub.Keys.P256DH); err != nil {
+		return Subscription{}, err
+	}
+
+	if encrypted.Keys.Auth, err = seal("auth", sub.Keys.Auth); err != nil {
+		return Subscription{}, err
+	}
+
+	return encrypted, nil
+}
+
+func (c *SubscriptionCodec) Decrypt(recordID string, sub Subscription) (Subscription, error) {
+	origin, token, err := splitEndpoint(sub.Endpoint)
+	if err != nil {
+		return Subscription{}, err
+	}
+
+	decrypted := sub
+
+	if sealed := strings.TrimPrefix(token, "/"); strings.HasPrefix(sealed, sealedPrefix) {
+		if token, err = c.open("endpoint", origin, recordID, sealed); err != nil {
+			return Subscription{}, err
+		}
+
+		decrypted.Endpoint = origin + token
+	}
+
+	if decrypted.Keys.P256DH, err = c.open("p256dh", origin, recordID, sub.Keys.P256DH); err != nil {
+		return Subscription{}, err
+	}
+
+	if decrypted.Keys.Auth, err = c.open("auth", origin, recordID, sub.Keys.Auth); err != nil {
+		return Subscription{}, err
+	}
+
+	return decrypted, nil
+}
+
+// Stale reports whether sub is in clear text or sealed with an old key.
+func (c *SubscriptionCodec) Stale(sub Subscription) bool {
+	c.mu.RLock()
+	current := c.current
+	c.mu.RUnlock()
+
+	id, _, ok := parseSealed(sub.Keys.Auth)
+
+	return !ok || id != current
+}
+
+func (c *SubscriptionCodec) open(field string, origin string, recordID string, value string) (string, error) {
+	if !strings.HasPrefix(value, sealedPrefix) {
+		return value, nil
+	}
+
+	id, data, ok := parseSealed(value)
+	if !ok {
+		return "", errors.New("malformed sealed value")
+	}
+
+	c.mu.RLock()
+	aead := c.keys[id]
+	c.mu.RUnlock()
+
+	if aead == nil {
+		return "", errors.New("unknown key id")
+	}
+
+	sealed, err := base64.RawURLEncoding.DecodeString(data)
+	if err != nil {
+		return "", err
+	}
+
+	if len(sealed) < aead.NonceSize() {
+		return "", errors.New("malformed sealed value")
+	}
+
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], sealedData(field, origin, recordID))
+	if err != nil {
+		return "", err
+	}
+
+	return string(plain), nil
+}
+
+// sealedData separates the parts with NUL bytes, which neither a field name
+// nor an origin can contain.
+func sealedData(field string, origin string, recordID string) []byte {
+	return []byte(field + "\x00" + origin + "\x00" + recordID)
+}
+
+func validCodecKeyID(id string) bool {
+	if id == "" {
+		return false
+	}
+
+	for _, r := range id {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '-', r == '_', r == '.':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+func parseSealed(value string) (string, string, bool) {
+	if !strings.HasPrefix(value, sealedPrefix) {
+		return "", "", false
+	}
+
+	return strings.Cut(value[len(sealedPrefix):], sealedPrefix)
+}
+
+func splitEndpoint(endpoint string) (string, string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", "", err
+	}
+
+	if u.Scheme == "" || u.Host == "" {
+		return "", "", errors.New("endpoint is not an absolute url")
+	}
+
+	origin := u.Scheme + "://" + u.Host
+
+	return origin, strings.TrimPrefix(endpoint, origin), nil
+}
+
+// EncryptedStore binds sealed values to the record ID, so records can't be
+// moved between IDs without being opened and sealed again.
+type EncryptedStore struct {
+	store SubscriptionStore
+	codec *SubscriptionCodec
+}
+
+func NewEncryptedStore(store SubscriptionStore, codec *SubscriptionCodec) *EncryptedStore {
+	return &EncryptedStore{
+		store: store,
+		codec: codec,
+	}
+}
+
+func (s *EncryptedStore) Put(ctx context.Context, rec *SubscriptionRecord) error {
+	sub, err := s.codec.Encrypt(rec.ID, rec.Subscription)
+	if err != nil {
+		return err
+	}
+
+	sealed := *rec
+	sealed.Subscription = sub
+
+	return s.store.Put(ctx, &sealed)
+}
+
+func (s *EncryptedStore) Get(ctx context.Context, id string) (*SubscriptionRecord, error) {
+	rec, err := s.store.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.open(rec)
+}
+
+func (s *EncryptedStore) Delete(ctx context.Context, id string) (bool, error) {
+	return s.store.Delete(ctx, id)
+}
+
+func (s *EncryptedStore) Range(ctx context.Context, fn func(rec *SubscriptionRecord) bool) error {
+	var openErr error
+
+	err := s.store.Range(ctx, func(rec *SubscriptionRecord) bool {
+		opened, err := s.open(rec)
+		if err != nil {
+			openErr = err
+			return false
+		}
+
+		return fn(opened)
+	})
+	if err != nil {
+		return err
+	}
+
+	return openErr
+}
+
+// Reencrypt should run after Rotate and before removing the old key.
+func (s *EncryptedStore) Reencrypt(ctx context.Context) (int, error) {
+	var stale []*SubscriptionRecord
+
+	err := s.store.Range(ctx, func(rec *SubscriptionRecord) bool {
+		if s.codec.Stale(rec.Subscription) {
+			copied := *rec
+			stale = append(stale, &copied)
+		}
+
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for i, rec := range stale {
+		opened, err := s.open(rec)
+		if err != nil {
+			return i, err
+		}
+
+		if err := s.Put(ctx, opened); err != nil {
+			return i, err
+		}
+	}
+
+	return len(stale), nil
+}
+
+func (s *EncryptedStore) open(rec *SubscriptionRecord) (*SubscriptionRecord, error) {
+	sub, err := s.codec.Decrypt(rec.ID, rec.Subscription)
+	if err != nil {
+		return nil, err
+	}
+
+	opened := *rec
+	opened.Subscription = sub
+
+	return &opened, nil
+}
@@ -0,0 +1,156 @@
+package webpush
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestEncryptedStore(t *testing.T) {
+	ctx := context.Background()
+
+	codec := NewSubscriptionCodec()
+	if err := codec.Add("k1", bytes.Repeat([]byte{1}, 32)); err != nil {
+		t.Fatal(err)
+	}
+
+	backing := NewMemorySubscriptionStore()
+	store := NewEncryptedStore(backing, codec)
+
+	sub := testInfo().Subscription
+	rec := NewSubscriptionRecord(sub)
+	if err := store.Put(ctx, rec); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Sealed at rest", func(t *testing.T) {
+		raw, err := backing.Get(ctx, rec.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !strings.HasPrefix(raw.Subscription.Endpoint, "https://test-ns.com/$k1$") {
+			t.Fatal("Unexpected endpoint", raw.Subscription.Endpoint)
+		}
+
+		for _, value := range []string{raw.Subscription.Keys.P256DH, raw.Subscription.Keys.Auth, raw.Subscription.Endpoint} {
+			if strings.Contains(value, "token") || value == sub.Keys.P256DH || value == sub.Keys.Auth {
+				t.Fatal("Value stored in clear text", value)
+			}
+		}
+	})
+
+	t.Run("Opened on load", func(t *testing.T) {
+		loaded, err := store.Get(ctx, rec.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if loaded.Subscription != sub {
+			t.Fatal("Unexpected subscription", loaded.Subscription)
+		}
+
+		count := 0
+		if err := store.Range(ctx, func(rec *SubscriptionRecord) bool {
+			count++
+			return rec.Subscription == sub
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		if count != 1 {
+			t.Fatal("Unexpected record count", count)
+		}
+	})
+
+	t.Run("Sealed values are bound to their record", func(t *testing.T) {
+		raw, err := backing.Get(ctx, rec.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := codec.Decrypt("other", raw.Subscription); err == nil {
+			t.Fatal("Sealed subscription was opened under another record id")
+		}
+	})
+
+	t.Run("Clear text rows pass through", func(t *testing.T) {
+		plain := sub
+		plain.Endpoint = "https://test-ns.com/ns/plain"
+		if err := backing.Put(ctx, NewSubscriptionRecord(plain)); err != nil {
+			t.Fatal(err)
+		}
+
+		loaded, err := store.Get(ctx, plain.ID())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if loaded.Subscription != plain {
+			t.Fatal("Clear text subscription was altered")
+		}
+	})
+
+	t.Run("Tampering is detected", func(t *testing.T) {
+		raw, err := backing.Get(ctx, rec.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		moved := *raw
+		moved.ID = "moved"
+		moved.Subscription.Endpoint = strings.Replace(moved.Subscription.Endpoint, "test-ns.com", "evil.com", 1)
+		if err := backing.Put(ctx, &moved); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := store.Get(ctx, "moved"); err == nil {
+			t.Fatal("Record moved to another origin was opened")
+		}
+
+		if _, err := backing.Delete(ctx, "moved"); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("Key rotation", func(t *testing.T) {
+		if err := codec.Rotate("k2", bytes.Repeat([]byte{2}, 32)); err != nil {
+			t.Fatal(err)
+		}
+
+		count, err := store.Reencrypt(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if count != 2 {
+			t.Fatal("Expected both records to be rewritten, got", count)
+		}
+
+		if err := codec.Remove("k1"); err != nil {
+			t.Fatal(err)
+		}
+
+		loaded, err := store.Get(ctx, rec.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if loaded.Subscription != sub {
+			t.Fatal("Unexpected subscription after rotation", loaded.Subscription)
+		}
+
+		if count, err := store.Reencrypt(ctx); err != nil || count != 0 {
+			t.Fatal("Unexpected second reencryption", count, err)
+		}
+	})
+
+	t.Run("Reject invalid key ids", func(t *testing.T) {
+		for _, id := range []string{"", "a$b", "a/b"} {
+			if err := codec.Add(id, bytes.Repeat([]byte{3}, 32)); err == nil {
+				t.Fatal("Key id was accepted", id)
+			}
+		}
+	})
+}
@@ -0,0 +1,185 @@
+package webpush
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+
+	"github.com/Firebain/webpush-go/ece"
+)
+
+const DeclarativeMagic = 8030
+
+type DeclarativeAction struct {
+	Action   string `json:"action"`
+	Title    string `json:"title"`
+	Navigate string `json:"navigate"`
+	Icon     string `json:"icon,omitempty"`
+}
+
+type DeclarativeNotification struct {
+	Title              string              `json:"title"`
+	Navigate           string              `json:"navigate"`
+	Body               string              `json:"body,omitempty"`
+	Icon               string              `json:"icon,omitempty"`
+	Badge              string              `json:"badge,omitempty"`
+	Image              string              `json:"image,omitempty"`
+	Lang               string              `json:"lang,omitempty"`
+	Dir                NotificationDir     `json:"dir,omitempty"`
+	Tag                string              `json:"tag,omitempty"`
+	Renotify           bool                `json:"renotify,omitempty"`
+	RequireInteraction bool                `json:"requireInteraction,omitempty"`
+	Silent             bool                `json:"silent,omitempty"`
+	Actions            []DeclarativeAction `json:"actions,omitempty"`
+	Vibrate            []int               `json:"vibrate,omitempty"`
+	Timestamp          int64               `json:"timestamp,omitempty"`
+	Data               any                 `json:"data,omitempty"`
+}
+
+type DeclarativePayload struct {
+	Notification DeclarativeNotification
+	AppBadge     *uint64
+	Mutable      bool
+	Fallback     *Notification
+}
+
+func NewDeclarativePayload(title string, navigate string) *DeclarativePayload {
+	return &DeclarativePayload{
+		Notification: DeclarativeNotification{
+			Title:    title,
+			Navigate: navigate,
+		},
+	}
+}
+
+func (p *DeclarativePayload) WithAppBadge(count uint64) *DeclarativePayload {
+	p.AppBadge = &count
+	return p
+}
+
+func (p *DeclarativePayload) WithMutable() *DeclarativePayload {
+	p.Mutable = true
+	return p
+}
+
+func (p *DeclarativePayload) WithAction(action string, title string, navigate string) *DeclarativePayload {
+	p.Notification.Actions = append(p.Notification.Actions, DeclarativeAction{
+		Action:   action,
+		Title:    title,
+		Navigate: navigate,
+	})
+	return p
+}
+
+func (p *DeclarativePayload) WithFallback(fallback *Notification) *DeclarativePayload {
+	p.Fallback = fallback
+	return p
+}
+
+func (p *DeclarativePayload) Validate() error {
+	n := &p.Notification
+
+	if n.Title == "" {
+		return errors.New("declarative notification title is empty")
+	}
+
+	if err := validateNavigate(n.Navigate); err != nil {
+		return err
+	}
+
+	links := []string{n.Icon, n.Badge, n.Image}
+	for _, action := range n.Actions {
+		if action.Action == "" || action.Title == "" {
+			return errors.New("notification action needs an action and a title")
+		}
+
+		if err := validateNavigate(action.Navigate); err != nil {
+			return err
+		}
+
+		links = append(links, action.Icon)
+	}
+
+	err := notificationFields{
+		links:    links,
+		dir:      n.Dir,
+		tag:      n.Tag,
+		renotify: n.Renotify,
+		silent:   n.Silent,
+		vibrate:  n.Vibrate,
+		actions:  len(n.Actions),
+	}.validate()
+	if err != nil {
+		return err
+	}
+
+	if p.Fallback != nil {
+		return p.Fallback.Validate()
+	}
+
+	return nil
+}
+
+func (p *DeclarativePayload) MarshalPayload() ([]byte, error) {
+	return p.marshal(ece.DefaultBlockSize)
+}
+
+func (p *DeclarativePayload) CheckSize(padSize int) error {
+	_, err := p.marshal(padSize)
+
+	return err
+}
+
+func (p *DeclarativePayload) MarshalJSON() ([]byte, error) {
+	fields := map[string]any{}
+
+	if p.Fallback != nil {
+		data, err := json.Marshal(p.Fallback)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := json.Unmarshal(data, &fields); err != nil {
+			return nil, err
+		}
+	}
+
+	fields["web_push"] = DeclarativeMagic
+	fields["notification"] = p.Notification
+
+	if p.AppBadge != nil {
+		fields["app_badge"] = *p.AppBadge
+	}
+
+	if p.Mutable {
+		fields["mutable"] = true
+	}
+
+	return json.Marshal(fields)
+}
+
+func (p *DeclarativePayload) marshal(padSize int) ([]byte, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+
+	data, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkPayloadSize(data, padSize); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func validateNavigate(navigate string) error {
+	u, err := url.Parse(navigate)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return errors.New("navigate must be an absolute http(s) url")
+	}
+
+	return nil
+}
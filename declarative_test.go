@@ -0,0 +1,54 @@
+package webpush
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestDeclarativePayload(t *testing.T) {
+	t.Run("Marshal with fallback", func(t *testing.T) {
+		data, err := NewDeclarativePayload("Order shipped", "https://example.com/orders/42").
+			WithAction("track", "Track", "https://example.com/orders/42/track").
+			WithAppBadge(3).
+			WithFallback(NewNotification("Order shipped").WithData(map[string]string{"url": "/orders/42"})).
+			MarshalPayload()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var decoded struct {
+			WebPush      int                     `json:"web_push"`
+			Notification DeclarativeNotification `json:"notification"`
+			AppBadge     uint64                  `json:"app_badge"`
+			Title        string                  `json:"title"`
+			Data         map[string]string       `json:"data"`
+		}
+		if err := json.Unmarshal(data, &decoded); err != nil {
+			t.Fatal(err)
+		}
+
+		if decoded.WebPush != DeclarativeMagic || decoded.Notification.Navigate != "https://example.com/orders/42" || decoded.AppBadge != 3 {
+			t.Fatal("Unexpected declarative fields", string(data))
+		}
+
+		if decoded.Title != "Order shipped" || decoded.Data["url"] != "/orders/42" {
+			t.Fatal("Unexpected fallback fields", string(data))
+		}
+	})
+
+	t.Run("Validate", func(t *testing.T) {
+		for _, p := range []*DeclarativePayload{
+			NewDeclarativePayload("", "https://example.com"),
+			NewDeclarativePayload("Hi", ""),
+			NewDeclarativePayload("Hi", "/relative"),
+			NewDeclarativePayload("Hi", "https://example.com").WithAction("open", "Open", "javascript:alert(1)"),
+			NewDeclarativePayload("Hi", "https://example.com").WithFallback(NewNotification("")),
+			{Notification: DeclarativeNotification{Title: "Hi", Navigate: "https://example.com", Icon: "%zz"}},
+			{Notification: DeclarativeNotification{Title: "Hi", Navigate: "https://example.com", Vibrate: []int{-1}}},
+		} {
+			if _, err := p.MarshalPayload(); err == nil {
+				t.Fatalf("Invalid payload was accepted %+v", p)
+			}
+		}
+	})
+}
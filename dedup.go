@@ -0,0 +1,138 @@
+package webpush
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/Firebain/webpush-go/clock"
+)
+
+const (
+	DefaultDedupWindow     = time.Hour
+	DefaultDedupMaxEntries = 100000
+)
+
+const maxTopicLength = 32
+
+type DedupStore interface {
+	Reserve(scope string, key string, now time.Time) bool
+	Release(scope string, key string)
+}
+
+type dedupRecord struct {
+	id   [sha256.Size]byte
+	seen time.Time
+}
+
+type MemoryDedupStore struct {
+	window     time.Duration
+	maxEntries int
+
+	mu      sync.Mutex
+	order   *list.List
+	records map[[sha256.Size]byte]*list.Element
+}
+
+func NewMemoryDedupStore(window time.Duration, maxEntries int) *MemoryDedupStore {
+	if window <= 0 {
+		window = DefaultDedupWindow
+	}
+
+	if maxEntries <= 0 {
+		maxEntries = DefaultDedupMaxEntries
+	}
+
+	return &MemoryDedupStore{
+		window:     window,
+		maxEntries: maxEntries,
+		order:      list.New(),
+		records:    make(map[[sha256.Size]byte]*list.Element),
+	}
+}
+
+func (s *MemoryDedupStore) Reserve(scope string, key string, now time.Time) bool {
+	id := dedupID(scope, key)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for elem := s.order.Front(); elem != nil; elem = s.order.Front() {
+		if now.Sub(elem.Value.(*dedupRecord).seen) < s.window {
+			break
+		}
+
+		s.remove(elem)
+	}
+
+	if _, ok := s.records[id]; ok {
+		return false
+	}
+
+	s.records[id] = s.order.PushBack(&dedupRecord{id: id, seen: now})
+
+	for s.order.Len() > s.maxEntries {
+		s.remove(s.order.Front())
+	}
+
+	return true
+}
+
+func (s *MemoryDedupStore) Release(scope string, key string) {
+	id := dedupID(scope, key)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if elem, ok := s.records[id]; ok {
+		s.remove(elem)
+	}
+}
+
+func (s *MemoryDedupStore) remove(elem *list.Element) {
+	s.order.Remove(elem)
+	delete(s.records, elem.Value.(*dedupRecord).id)
+}
+
+var ErrDeduplicated = errors.New("message was deduplicated")
+
+func DedupMiddleware(store DedupStore, clk clock.Clock) SendMiddleware {
+	clk = clock.OrSystem(clk)
+
+	return func(next SendFunc) SendFunc {
+		return func(ctx context.Context, msg *Message) (*SendResult, error) {
+			if msg.Options == nil || msg.Options.IdempotencyKey == "" {
+				return next(ctx, msg)
+			}
+
+			scope := msg.Info.Subscription.ID()
+			key := msg.Options.IdempotencyKey
+
+			if !store.Reserve(scope, key, clk.Now()) {
+				recordOutcome(metricsFromContext(ctx), msg.Info.Subscription.Endpoint, outcomeDeduplicated)
+				return &SendResult{Deduplicated: true}, nil
+			}
+
+			res, err := next(ctx, msg)
+			if err != nil || res.Response == nil || res.Response.StatusCode >= 300 {
+				store.Release(scope, key)
+			}
+
+			return res, err
+		}
+	}
+}
+
+func dedupID(scope string, key string) [sha256.Size]byte {
+	return sha256.Sum256([]byte(scope + "\x00" + key))
+}
+
+func topicFromKey(key string) string {
+	hash := sha256.Sum256([]byte(key))
+
+	return base64.RawURLEncoding.EncodeToString(hash[:])[:maxTopicLength]
+}
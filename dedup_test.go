@@ -0,0 +1,73 @@
+package webpush
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Firebain/webpush-go/auth"
+	"github.com/Firebain/webpush-go/ece"
+)
+
+func TestDeduplication(t *testing.T) {
+	client := clientMock{}
+	webpush := NewWebPushClient(&client, &auth.SimpleJwtSigner{}, &ece.Aes128GcmEncoder{}, WithDedupStore(NewMemoryDedupStore(0, 0)))
+
+	options := &WebPushOptions{
+		TTL:            60,
+		IdempotencyKey: "order-42-shipped",
+		Collapsible:    true,
+	}
+
+	res, err := webpush.SendWithResult(context.Background(), []byte("Shipped"), testInfo(), options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Response.Body.Close()
+
+	if res.Deduplicated {
+		t.Fatal("First send was deduplicated")
+	}
+
+	if topic := client.Request.Header.Get("Topic"); len(topic) != 32 {
+		t.Fatal("Unexpected topic", topic)
+	}
+
+	client.Called = false
+
+	res, err = webpush.SendWithResult(context.Background(), []byte("Shipped"), testInfo(), options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !res.Deduplicated || res.Response != nil || client.Called {
+		t.Fatal("Repeated send was not deduplicated")
+	}
+
+	if _, err := webpush.Send([]byte("Shipped"), testInfo(), options); !errors.Is(err, ErrDeduplicated) {
+		t.Fatal("Expected ErrDeduplicated, got", err)
+	}
+}
+
+func TestMemoryDedupStore(t *testing.T) {
+	store := NewMemoryDedupStore(time.Minute, 2)
+	now := time.Unix(1710588595, 0)
+
+	if !store.Reserve("sub", "a", now) || store.Reserve("sub", "a", now) {
+		t.Fatal("Key was not reserved")
+	}
+
+	if !store.Reserve("other", "a", now) {
+		t.Fatal("Keys are not scoped per subscription")
+	}
+
+	store.Release("sub", "a")
+	if !store.Reserve("sub", "a", now) {
+		t.Fatal("Released key is still reserved")
+	}
+
+	if !store.Reserve("sub", "b", now) || !store.Reserve("other", "a", now.Add(time.Minute)) {
+		t.Fatal("Keys outside the window or bound were kept")
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"encoding/binary"
 	"errors"
 	"io"
+	"time"
 
 	"github.com/Firebain/webpush-go/internal/base64"
 )
@@ -17,6 +18,15 @@ type WebPushEncoder interface {
 	EncryptPayload(p256dh string, auth string, data []byte) ([]byte, error)
 }
 
+type EncryptTrace struct {
+	KeyDerived func(d time.Duration)
+	Encrypted  func(d time.Duration, size int)
+}
+
+type TracingEncoder interface {
+	EncryptPayloadWithTrace(p256dh string, auth string, data []byte, trace *EncryptTrace) ([]byte, error)
+}
+
 const DefaultBlockSize = 128
 
 const defaultRs = 4096
@@ -31,10 +41,25 @@ const nonceLength = 12
 const authenticationTagLength = 16
 const delimiterLength = 1
 
-func genSalt() ([]byte, error) {
+const MaxMessageSize = 4096
+
+var ErrPayloadTooLarge = errors.New("payload too large")
+
+func EncryptedSize(dataLength int, padSize int) int {
+	dataWithDelLength := dataLength + delimiterLength
+
+	padLength := 0
+	if padSize != 0 {
+		padLength = padSize - dataWithDelLength%padSize
+	}
+
+	return headerLength + dataWithDelLength + padLength + authenticationTagLength
+}
+
+func genSalt(random io.Reader) ([]byte, error) {
 	salt := make([]byte, 16)
 
-	_, err := io.ReadFull(rand.Reader, salt[:])
+	_, err := io.ReadFull(random, salt[:])
 	if err != nil {
 		return salt, err
 	}
@@ -42,6 +67,23 @@ func genSalt() ([]byte, error) {
 	return salt, nil
 }
 
+// genLocalKey reads the scalar directly instead of calling GenerateKey,
+// which may consume a variable amount of randomness and so breaks
+// reproducible output for seeded readers.
+func genLocalKey(random io.Reader) (*ecdh.PrivateKey, error) {
+	keyBytes := make([]byte, 32)
+	for {
+		if _, err := io.ReadFull(random, keyBytes); err != nil {
+			return nil, err
+		}
+
+		key, err := ecdh.P256().NewPrivateKey(keyBytes)
+		if err == nil {
+			return key, nil
+		}
+	}
+}
+
 func deriveKeyAndNonce(salt []byte, auth []byte, remoteKey *ecdh.PublicKey, localKey *ecdh.PrivateKey) ([]byte, []byte, error) {
 	sharedSecret, err := localKey.ECDH(remoteKey)
 	if err != nil {
@@ -69,15 +111,30 @@ func deriveKeyAndNonce(salt []byte, auth []byte, remoteKey *ecdh.PublicKey, loca
 	return key, nonce, nil
 }
 
-type Aes128GcmEncoder struct{}
+type Aes128GcmEncoder struct {
+	Rand io.Reader
+}
 
-func (*Aes128GcmEncoder) Encrypt(
+func (e *Aes128GcmEncoder) Encrypt(
 	salt []byte,
 	localKey *ecdh.PrivateKey,
 	p256dh []byte,
 	auth []byte,
 	padSize int,
 	data []byte,
+) ([]byte, error) {
+	return e.encrypt(salt, localKey, p256dh, auth, padSize, data, nil, time.Now())
+}
+
+func (*Aes128GcmEncoder) encrypt(
+	salt []byte,
+	localKey *ecdh.PrivateKey,
+	p256dh []byte,
+	auth []byte,
+	padSize int,
+	data []byte,
+	trace *EncryptTrace,
+	start time.Time,
 ) ([]byte, error) {
 	remoteKey, err := ecdh.P256().NewPublicKey(p256dh)
 	if err != nil {
@@ -89,6 +146,12 @@ func (*Aes128GcmEncoder) Encrypt(
 		return nil, err
 	}
 
+	if trace != nil && trace.KeyDerived != nil {
+		trace.KeyDerived(time.Since(start))
+	}
+
+	start = time.Now()
+
 	c, err := aes.NewCipher(key)
 	if err != nil {
 		return nil, err
@@ -128,12 +191,20 @@ func (*Aes128GcmEncoder) Encrypt(
 	ciphertext := gcm.Seal(dataBuf[:0], nonce, dataBuf[:fullDataSize], nil)
 	recordBuf.Write(ciphertext)
 
+	if trace != nil && trace.Encrypted != nil {
+		trace.Encrypted(time.Since(start), recordBuf.Len())
+	}
+
 	return recordBuf.Bytes(), err
 }
 
 func (e *Aes128GcmEncoder) EncryptPayload(p256dhEncoded string, authEncoded string, data []byte) ([]byte, error) {
+	return e.EncryptPayloadWithTrace(p256dhEncoded, authEncoded, data, nil)
+}
+
+func (e *Aes128GcmEncoder) EncryptPayloadWithTrace(p256dhEncoded string, authEncoded string, data []byte, trace *EncryptTrace) ([]byte, error) {
 	if len(data) > defaultRs {
-		return nil, errors.New("payload too large")
+		return nil, ErrPayloadTooLarge
 	}
 
 	p256dh, err := base64.DecodeUrlBase64(p256dhEncoded)
@@ -154,22 +225,32 @@ func (e *Aes128GcmEncoder) EncryptPayload(p256dhEncoded string, authEncoded stri
 		return nil, errors.New("invalid auth secret")
 	}
 
-	salt, err := genSalt()
+	random := e.Rand
+	if random == nil {
+		random = rand.Reader
+	}
+
+	salt, err := genSalt(random)
 	if err != nil {
 		return nil, err
 	}
 
-	localKey, err := ecdh.P256().GenerateKey(rand.Reader)
+	// KeyDerived covers generating the local key as well.
+	start := time.Now()
+
+	localKey, err := genLocalKey(random)
 	if err != nil {
 		return nil, err
 	}
 
-	return e.Encrypt(
+	return e.encrypt(
 		salt,
 		localKey,
 		p256dh,
 		auth,
 		DefaultBlockSize,
 		data,
+		trace,
+		start,
 	)
 }
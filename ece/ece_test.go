@@ -99,3 +99,22 @@ func TestEceEncoding(t *testing.T) {
 		}
 	})
 }
+
+func TestEncryptedSize(t *testing.T) {
+	encoder := Aes128GcmEncoder{}
+
+	for _, size := range []int{0, 1, 126, 127, 128, 1000} {
+		encrypted, err := encoder.EncryptPayload(
+			"BCVxsr7N_eNgVRqvHtD0zTZsEc6-VV-JvLexhqUzORcxaOzi6-AYWXvTBHm4bjyPjs7Vd8pZGH6SRpkNtoIAiw4",
+			"BTBZMqHH6r4Tts7J_aSIgg",
+			make([]byte, size),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(encrypted) != EncryptedSize(size, DefaultBlockSize) {
+			t.Fatal("Unexpected encrypted size for", size, len(encrypted))
+		}
+	}
+}
@@ -0,0 +1,461 @@
+package webpush
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Filter selects subscription records; a nil Filter matches every record.
+type Filter interface {
+	Match(rec *SubscriptionRecord) bool
+}
+
+type FilterFunc func(rec *SubscriptionRecord) bool
+
+func (f FilterFunc) Match(rec *SubscriptionRecord) bool {
+	return f(rec)
+}
+
+func matches(filter Filter, rec *SubscriptionRecord) bool {
+	return filter == nil || filter.Match(rec)
+}
+
+type tagOp int
+
+const (
+	opExists tagOp = iota
+	opEqual
+	opNotEqual
+	opLess
+	opLessEqual
+	opGreater
+	opGreaterEqual
+)
+
+type tagFilter struct {
+	key    string
+	op     tagOp
+	values []string
+}
+
+func (f *tagFilter) Match(rec *SubscriptionRecord) bool {
+	values := rec.Tags[f.key]
+
+	switch f.op {
+	case opExists:
+		return len(values) > 0
+	case opNotEqual:
+		for _, value := range values {
+			for _, want := range f.values {
+				if value == want {
+					return false
+				}
+			}
+		}
+
+		return true
+	}
+
+	for _, value := range values {
+		for _, want := range f.values {
+			if compareTag(f.op, value, want) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func compareTag(op tagOp, value string, want string) bool {
+	if op == opEqual {
+		return value == want
+	}
+
+	c := compareVersions(value, want)
+
+	switch op {
+	case opLess:
+		return c < 0
+	case opLessEqual:
+		return c <= 0
+	case opGreater:
+		return c > 0
+	case opGreaterEqual:
+		return c >= 0
+	}
+
+	return false
+}
+
+// compareVersions orders dotted values segment by segment, numerically when
+// both segments are numbers, so that "3.10" sorts after "3.9".
+func compareVersions(a string, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		an, aerr := strconv.ParseUint(as[i], 10, 64)
+		bn, berr := strconv.ParseUint(bs[i], 10, 64)
+
+		switch {
+		case aerr == nil && berr == nil && an != bn:
+			if an < bn {
+				return -1
+			}
+
+			return 1
+		case (aerr != nil || berr != nil) && as[i] != bs[i]:
+			return strings.Compare(as[i], bs[i])
+		}
+	}
+
+	return len(as) - len(bs)
+}
+
+type andFilter []Filter
+
+func (f andFilter) Match(rec *SubscriptionRecord) bool {
+	for _, filter := range f {
+		if !filter.Match(rec) {
+			return false
+		}
+	}
+
+	return true
+}
+
+type orFilter []Filter
+
+func (f orFilter) Match(rec *SubscriptionRecord) bool {
+	for _, filter := range f {
+		if filter.Match(rec) {
+			return true
+		}
+	}
+
+	return false
+}
+
+type notFilter struct {
+	filter Filter
+}
+
+func (f notFilter) Match(rec *SubscriptionRecord) bool {
+	return !f.filter.Match(rec)
+}
+
+func HasTag(key string, values ...string) Filter {
+	if len(values) == 0 {
+		return &tagFilter{key: key, op: opExists}
+	}
+
+	return &tagFilter{key: key, op: opEqual, values: values}
+}
+
+func And(filters ...Filter) Filter {
+	return andFilter(filters)
+}
+
+func Or(filters ...Filter) Filter {
+	return orFilter(filters)
+}
+
+func Not(filter Filter) Filter {
+	return notFilter{filter: filter}
+}
+
+// ParseFilter parses expressions such as
+//
+//	locale = "de-DE" and app_version >= 3.2 and (topic in (news, sports) or not user)
+func ParseFilter(expr string) (Filter, error) {
+	tokens, err := tokenizeFilter(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &filterParser{tokens: tokens}
+
+	filter, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+
+	if tok := p.peek(); tok.kind != tokenEnd {
+		return nil, fmt.Errorf("unexpected %q at offset %d", tok.text, tok.pos)
+	}
+
+	return filter, nil
+}
+
+type tokenKind int
+
+const (
+	tokenEnd tokenKind = iota
+	tokenWord
+	tokenString
+	tokenOp
+	tokenLParen
+	tokenRParen
+	tokenComma
+)
+
+type filterToken struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("_-.:@/+", r)
+}
+
+func tokenizeFilter(expr string) ([]filterToken, error) {
+	var tokens []filterToken
+
+	for i := 0; i < len(expr); {
+		c := expr[i]
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(':
+			tokens = append(tokens, filterToken{kind: tokenLParen, text: "(", pos: i})
+			i++
+		case c == ')':
+			tokens = append(tokens, filterToken{kind: tokenRParen, text: ")", pos: i})
+			i++
+		case c == ',':
+			tokens = append(tokens, filterToken{kind: tokenComma, text: ",", pos: i})
+			i++
+		case c == '"':
+			end := i + 1
+			for end < len(expr) && expr[end] != '"' {
+				if expr[end] == '\\' {
+					end++
+				}
+				end++
+			}
+
+			if end >= len(expr) {
+				return nil, fmt.Errorf("unterminated string at offset %d", i)
+			}
+
+			text, err := strconv.Unquote(expr[i : end+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid string at offset %d: %w", i, err)
+			}
+
+			tokens = append(tokens, filterToken{kind: tokenString, text: text, pos: i})
+			i = end + 1
+		case strings.ContainsRune("=!<>", rune(c)):
+			end := i + 1
+			if end < len(expr) && expr[end] == '=' {
+				end++
+			}
+
+			op := expr[i:end]
+			if op == "!" {
+				return nil, fmt.Errorf("unexpected %q at offset %d", op, i)
+			}
+
+			tokens = append(tokens, filterToken{kind: tokenOp, text: op, pos: i})
+			i = end
+		default:
+			end := i
+			for end < len(expr) {
+				r := rune(expr[end])
+				if r >= 0x80 || isWordRune(r) {
+					end++
+					continue
+				}
+
+				break
+			}
+
+			if end == i {
+				return nil, fmt.Errorf("unexpected %q at offset %d", string(c), i)
+			}
+
+			tokens = append(tokens, filterToken{kind: tokenWord, text: expr[i:end], pos: i})
+			i = end
+		}
+	}
+
+	return append(tokens, filterToken{kind: tokenEnd, pos: len(expr)}), nil
+}
+
+type filterParser struct {
+	tokens []filterToken
+	pos    int
+}
+
+func (p *filterParser) peek() filterToken {
+	return p.tokens[p.pos]
+}
+
+func (p *filterParser) next() filterToken {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokenEnd {
+		p.pos++
+	}
+
+	return tok
+}
+
+func (p *filterParser) keyword(word string) bool {
+	tok := p.peek()
+	if tok.kind == tokenWord && strings.EqualFold(tok.text, word) {
+		p.pos++
+		return true
+	}
+
+	return false
+}
+
+func (p *filterParser) or() (Filter, error) {
+	filter, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+
+	filters := []Filter{filter}
+	for p.keyword("or") {
+		filter, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+
+		filters = append(filters, filter)
+	}
+
+	if len(filters) == 1 {
+		return filters[0], nil
+	}
+
+	return orFilter(filters), nil
+}
+
+func (p *filterParser) and() (Filter, error) {
+	filter, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+
+	filters := []Filter{filter}
+	for p.keyword("and") {
+		filter, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+
+		filters = append(filters, filter)
+	}
+
+	if len(filters) == 1 {
+		return filters[0], nil
+	}
+
+	return andFilter(filters), nil
+}
+
+func (p *filterParser) unary() (Filter, error) {
+	if p.keyword("not") {
+		filter, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+
+		return notFilter{filter: filter}, nil
+	}
+
+	return p.primary()
+}
+
+func (p *filterParser) primary() (Filter, error) {
+	tok := p.next()
+
+	switch tok.kind {
+	case tokenLParen:
+		filter, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+
+		if closing := p.next(); closing.kind != tokenRParen {
+			return nil, fmt.Errorf("expected ) at offset %d", closing.pos)
+		}
+
+		return filter, nil
+	case tokenWord, tokenString:
+	case tokenEnd:
+		return nil, errors.New("unexpected end of filter")
+	default:
+		return nil, fmt.Errorf("unexpected %q at offset %d", tok.text, tok.pos)
+	}
+
+	key := tok.text
+
+	if p.keyword("in") {
+		values, err := p.list()
+		if err != nil {
+			return nil, err
+		}
+
+		return &tagFilter{key: key, op: opEqual, values: values}, nil
+	}
+
+	if p.peek().kind != tokenOp {
+		return &tagFilter{key: key, op: opExists}, nil
+	}
+
+	opTok := p.next()
+
+	value := p.next()
+	if value.kind != tokenWord && value.kind != tokenString {
+		return nil, fmt.Errorf("expected a value at offset %d", value.pos)
+	}
+
+	ops := map[string]tagOp{
+		"=":  opEqual,
+		"==": opEqual,
+		"!=": opNotEqual,
+		"<":  opLess,
+		"<=": opLessEqual,
+		">":  opGreater,
+		">=": opGreaterEqual,
+	}
+
+	op, ok := ops[opTok.text]
+	if !ok {
+		return nil, fmt.Errorf("unknown operator %q at offset %d", opTok.text, opTok.pos)
+	}
+
+	return &tagFilter{key: key, op: op, values: []string{value.text}}, nil
+}
+
+func (p *filterParser) list() ([]string, error) {
+	if open := p.next(); open.kind != tokenLParen {
+		return nil, fmt.Errorf("expected ( at offset %d", open.pos)
+	}
+
+	var values []string
+	for {
+		tok := p.next()
+		if tok.kind != tokenWord && tok.kind != tokenString {
+			return nil, fmt.Errorf("expected a value at offset %d", tok.pos)
+		}
+
+		values = append(values, tok.text)
+
+		switch sep := p.next(); sep.kind {
+		case tokenComma:
+		case tokenRParen:
+			return values, nil
+		default:
+			return nil, fmt.Errorf("expected , or ) at offset %d", sep.pos)
+		}
+	}
+}
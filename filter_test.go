@@ -0,0 +1,76 @@
+package webpush
+
+import (
+	"testing"
+)
+
+func TestParseFilter(t *testing.T) {
+	rec := &SubscriptionRecord{
+		Tags: Tags{
+			"user":        {"42"},
+			"locale":      {"de-DE"},
+			"app_version": {"3.10.1"},
+			"topic":       {"news", "sports"},
+		},
+	}
+
+	for _, tc := range []struct {
+		expr  string
+		match bool
+	}{
+		{`user = 42`, true},
+		{`user == "42"`, true},
+		{`user != 42`, false},
+		{`locale = "en-US"`, false},
+		{`topic = sports`, true},
+		{`topic != weather`, true},
+		{`topic != news`, false},
+		{`topic in (weather, news)`, true},
+		{`topic in ("weather")`, false},
+		{`app_version >= 3.9`, true},
+		{`app_version < 3.10`, false},
+		{`app_version <= 3.10.1`, true},
+		{`app_version > 3.10.1`, false},
+		{`premium`, false},
+		{`not premium`, true},
+		{`locale = de-DE and (topic = weather or user = 42)`, true},
+		{`locale = de-DE and topic = weather or user = 7`, false},
+		{`NOT locale = de-DE OR topic = news`, true},
+	} {
+		filter, err := ParseFilter(tc.expr)
+		if err != nil {
+			t.Fatal(tc.expr, err)
+		}
+
+		if filter.Match(rec) != tc.match {
+			t.Fatal("Unexpected match result for", tc.expr)
+		}
+	}
+
+	for _, expr := range []string{
+		``,
+		`user =`,
+		`user ! 42`,
+		`(user = 42`,
+		`topic in (news`,
+		`user = "42`,
+		`user = 42 locale`,
+	} {
+		if _, err := ParseFilter(expr); err == nil {
+			t.Fatal("Invalid expression was accepted", expr)
+		}
+	}
+}
+
+func TestFilterBuilders(t *testing.T) {
+	rec := &SubscriptionRecord{Tags: Tags{"locale": {"fr-FR"}, "topic": {"news"}}}
+
+	filter := And(HasTag("locale", "de-DE", "fr-FR"), Not(HasTag("premium")), Or(HasTag("topic", "news"), HasTag("user")))
+	if !filter.Match(rec) {
+		t.Fatal("Filter did not match")
+	}
+
+	if And(filter, HasTag("user")).Match(rec) {
+		t.Fatal("Filter matched a record without user tag")
+	}
+}
@@ -1,3 +1,3 @@
 module github.com/Firebain/webpush-go
 
-go 1.22.0
+go 1.24.0
@@ -0,0 +1,101 @@
+package webpush
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+)
+
+// MessageHandle never serializes VAPID keys. Per-call VapidDetails are kept
+// in memory only, so a decoded handle needs a tenant registry, key ring or
+// key provider to be cancelled or replaced.
+type MessageHandle struct {
+	Location string           `json:"location"`
+	Topic    string           `json:"topic,omitempty"`
+	Endpoint string           `json:"endpoint"`
+	Keys     SubscriptionKeys `json:"keys"`
+	KeyID    string           `json:"keyId,omitempty"`
+	TenantID string           `json:"tenantId,omitempty"`
+	Options  WebPushOptions   `json:"options"`
+
+	vapid VapidDetails
+}
+
+func newMessageHandle(res *http.Response, info *WebPushInfo, options *WebPushOptions) *MessageHandle {
+	location, err := res.Location()
+	if err != nil {
+		return nil
+	}
+
+	handle := &MessageHandle{
+		Location: location.String(),
+		Endpoint: info.Subscription.Endpoint,
+		Keys:     info.Subscription.Keys,
+		KeyID:    info.Subscription.KeyID,
+		TenantID: info.TenantID,
+		vapid:    info.VapidDetails,
+	}
+
+	if res.Request != nil {
+		handle.Topic = res.Request.Header.Get("Topic")
+	}
+
+	if options != nil {
+		handle.Options = *options
+	} else {
+		handle.Options.TTL = DefaultTTL
+	}
+
+	return handle
+}
+
+func (h *MessageHandle) info() *WebPushInfo {
+	return &WebPushInfo{
+		Subscription: Subscription{
+			Endpoint: h.Endpoint,
+			Keys:     h.Keys,
+			KeyID:    h.KeyID,
+		},
+		VapidDetails: h.vapid,
+		TenantID:     h.TenantID,
+	}
+}
+
+func (c *WebPushClient) Cancel(ctx context.Context, handle *MessageHandle) (*http.Response, error) {
+	location, err := url.Parse(handle.Location)
+	if err != nil {
+		return nil, err
+	}
+
+	identity, err := c.vapidIdentity(ctx, handle.info())
+	if err != nil {
+		return nil, err
+	}
+
+	vapidHeader, _, err := identity.header(location)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "DELETE", handle.Location, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Add("Authorization", vapidHeader)
+
+	return c.httpClient.Do(req)
+}
+
+func (c *WebPushClient) Replace(ctx context.Context, handle *MessageHandle, payload []byte) (*http.Response, error) {
+	if handle.Topic == "" {
+		return nil, errors.New("message has no topic to replace")
+	}
+
+	options := handle.Options
+	options.Topic = handle.Topic
+	options.IdempotencyKey = ""
+
+	return c.SendWithContext(ctx, payload, handle.info(), &options)
+}
@@ -0,0 +1,140 @@
+package webpush
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/Firebain/webpush-go/auth"
+	"github.com/Firebain/webpush-go/ece"
+)
+
+type pushServiceMock struct {
+	Requests []*http.Request
+}
+
+func (p *pushServiceMock) Do(req *http.Request) (*http.Response, error) {
+	p.Requests = append(p.Requests, req)
+
+	res := &http.Response{
+		StatusCode: 201,
+		Header:     http.Header{},
+		Body:       io.NopCloser(bytes.NewReader([]byte{})),
+		Request:    req,
+	}
+
+	if req.Method == "DELETE" {
+		res.StatusCode = 204
+	} else {
+		res.Header.Set("Location", "/m/message-1")
+	}
+
+	return res, nil
+}
+
+func TestMessageHandle(t *testing.T) {
+	service := pushServiceMock{}
+	ring := NewKeyRing()
+	if err := ring.Add("v1", testInfo().VapidDetails); err != nil {
+		t.Fatal(err)
+	}
+
+	webpush := NewWebPushClient(&service, &auth.SimpleJwtSigner{}, &ece.Aes128GcmEncoder{}, WithKeyRing(ring))
+
+	info := testInfo()
+	info.VapidDetails = VapidDetails{}
+	info.Subscription.KeyID = "v1"
+
+	res, err := webpush.SendWithResult(context.Background(), []byte("Hello World!"), info, &WebPushOptions{TTL: 60, Topic: "news"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Response.Body.Close()
+
+	handle := res.Handle
+	if handle == nil || handle.Location != "https://test-ns.com/m/message-1" || handle.Topic != "news" || handle.KeyID != "v1" {
+		t.Fatalf("Unexpected handle %+v", handle)
+	}
+
+	encoded, err := json.Marshal(handle)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(string(encoded), testInfo().VapidDetails.PrivateKey) {
+		t.Fatal("Handle leaks the VAPID private key")
+	}
+
+	t.Run("Replace", func(t *testing.T) {
+		res, err := webpush.Replace(context.Background(), handle, []byte("Updated"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+
+		req := service.Requests[len(service.Requests)-1]
+		if req.Header.Get("Topic") != "news" || req.Header.Get("TTL") != "60" {
+			t.Fatal("Unexpected headers", req.Header)
+		}
+	})
+
+	t.Run("Cancel", func(t *testing.T) {
+		res, err := webpush.Cancel(context.Background(), handle)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+
+		req := service.Requests[len(service.Requests)-1]
+		if req.Method != "DELETE" || req.URL.String() != handle.Location {
+			t.Fatal("Unexpected request", req.Method, req.URL)
+		}
+
+		if !strings.HasPrefix(req.Header.Get("Authorization"), "vapid t=") {
+			t.Fatal("Delete request is not authenticated")
+		}
+	})
+
+	t.Run("Default client", func(t *testing.T) {
+		service := pushServiceMock{}
+		webpush := NewWebPushClient(&service, &auth.SimpleJwtSigner{}, &ece.Aes128GcmEncoder{})
+
+		res, err := webpush.SendWithResult(context.Background(), []byte("Hello World!"), testInfo(), &WebPushOptions{TTL: 60, Topic: "news"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Response.Body.Close()
+
+		cancelled, err := webpush.Cancel(context.Background(), res.Handle)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cancelled.Body.Close()
+
+		if !strings.HasPrefix(service.Requests[len(service.Requests)-1].Header.Get("Authorization"), "vapid t=") {
+			t.Fatal("Delete request is not authenticated")
+		}
+
+		encoded, err := json.Marshal(res.Handle)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var decoded MessageHandle
+		if err := json.Unmarshal(encoded, &decoded); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := webpush.Cancel(context.Background(), &decoded); err == nil {
+			t.Fatal("Decoded handle was cancelled without a key source")
+		}
+
+		if _, err := webpush.Replace(context.Background(), &decoded, []byte("Updated")); err == nil {
+			t.Fatal("Decoded handle was replaced without a key source")
+		}
+	})
+}
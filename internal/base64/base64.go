@@ -1,8 +1,15 @@
 package base64
 
-import "encoding/base64"
+import (
+	"encoding/base64"
+	"errors"
+)
 
 func DecodeUrlBase64(key string) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, errors.New("empty key")
+	}
+
 	if len(key)%4 == 0 && key[len(key)-1] == '=' {
 		return base64.URLEncoding.DecodeString(key)
 	} else {
@@ -0,0 +1,155 @@
+package webpush
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/Firebain/webpush-go/auth"
+)
+
+type keyRingEntry struct {
+	details VapidDetails
+	pair    *auth.VapidKeyPair
+}
+
+type KeyRing struct {
+	mu      sync.RWMutex
+	entries map[string]*keyRingEntry
+	current string
+}
+
+func NewKeyRing() *KeyRing {
+	return &KeyRing{
+		entries: make(map[string]*keyRingEntry),
+	}
+}
+
+func (r *KeyRing) Add(id string, details VapidDetails) error {
+	if id == "" {
+		return errors.New("key id is empty")
+	}
+
+	pair, err := details.KeyPair()
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.entries[id]; ok {
+		return errors.New("key id already exists")
+	}
+
+	r.entries[id] = &keyRingEntry{
+		details: details,
+		pair:    pair,
+	}
+
+	if r.current == "" {
+		r.current = id
+	}
+
+	return nil
+}
+
+func (r *KeyRing) Rotate(id string, details VapidDetails) error {
+	if err := r.Add(id, details); err != nil {
+		return err
+	}
+
+	return r.SetCurrent(id)
+}
+
+func (r *KeyRing) SetCurrent(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.entries[id]; !ok {
+		return errors.New("unknown key id")
+	}
+
+	r.current = id
+
+	return nil
+}
+
+func (r *KeyRing) Remove(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if id == r.current {
+		return errors.New("cannot remove current key")
+	}
+
+	if _, ok := r.entries[id]; !ok {
+		return errors.New("unknown key id")
+	}
+
+	delete(r.entries, id)
+
+	return nil
+}
+
+func (r *KeyRing) Current() (string, VapidDetails, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	entry, ok := r.entries[r.current]
+	if !ok {
+		return "", VapidDetails{}, errors.New("key ring is empty")
+	}
+
+	return r.current, entry.details, nil
+}
+
+func (r *KeyRing) Lookup(id string) (VapidDetails, error) {
+	entry, err := r.entry(id)
+	if err != nil {
+		return VapidDetails{}, err
+	}
+
+	return entry.details, nil
+}
+
+func (r *KeyRing) Assign(sub *Subscription) error {
+	id, _, err := r.Current()
+	if err != nil {
+		return err
+	}
+
+	sub.KeyID = id
+
+	return nil
+}
+
+func (r *KeyRing) PendingRotation(subs []Subscription) []Subscription {
+	r.mu.RLock()
+	current := r.current
+	r.mu.RUnlock()
+
+	var pending []Subscription
+	for _, sub := range subs {
+		if sub.KeyID != current {
+			pending = append(pending, sub)
+		}
+	}
+
+	return pending
+}
+
+func (r *KeyRing) entry(id string) (*keyRingEntry, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if id == "" {
+		return nil, errors.New("subscription has no key id")
+	}
+
+	entry, ok := r.entries[id]
+	if !ok {
+		return nil, errors.New("unknown key id")
+	}
+
+	return entry, nil
+}
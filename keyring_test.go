@@ -0,0 +1,97 @@
+package webpush
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Firebain/webpush-go/auth"
+	"github.com/Firebain/webpush-go/ece"
+)
+
+func TestKeyRing(t *testing.T) {
+	oldKeys, err := GenerateVapidKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newKeys, err := GenerateVapidKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ring := NewKeyRing()
+	if err := ring.Add("v1", VapidDetails{Subject: "example@push.com", VapidKeys: *oldKeys}); err != nil {
+		t.Fatal(err)
+	}
+
+	oldSub := Subscription{
+		Endpoint: "https://test-ns.com/ns/old",
+		Keys: SubscriptionKeys{
+			P256DH: "BFGGjgyqdoqg10kasOdjQ9M_XCGCUrHe9XdOtFtGgRQmxseX0rDCPnmkqUXK0sEhF30to0G4TonsvnxWq6BJrIA",
+			Auth:   "PVi3VfghXXXOELqDxy0oDA",
+		},
+	}
+	if err := ring.Assign(&oldSub); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ring.Rotate("v2", VapidDetails{Subject: "example@push.com", VapidKeys: *newKeys}); err != nil {
+		t.Fatal(err)
+	}
+
+	newSub := oldSub
+	newSub.Endpoint = "https://test-ns.com/ns/new"
+	if err := ring.Assign(&newSub); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Pending rotation", func(t *testing.T) {
+		pending := ring.PendingRotation([]Subscription{oldSub, newSub})
+		if len(pending) != 1 || pending[0].Endpoint != oldSub.Endpoint {
+			t.Fatal("Unexpected pending subscriptions", pending)
+		}
+	})
+
+	t.Run("Send with matching key", func(t *testing.T) {
+		client := clientMock{}
+		webpush := NewWebPushClient(&client, &auth.SimpleJwtSigner{}, &ece.Aes128GcmEncoder{}, WithKeyRing(ring))
+
+		for _, tc := range []struct {
+			sub  Subscription
+			keys *VapidKeys
+		}{
+			{oldSub, oldKeys},
+			{newSub, newKeys},
+		} {
+			res, err := webpush.Send([]byte("Hello World!"), &WebPushInfo{Subscription: tc.sub}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+
+			if !strings.HasSuffix(client.Request.Header.Get("Authorization"), "k="+tc.keys.PublicKey) {
+				t.Fatal("Wrong key used for", tc.sub.KeyID)
+			}
+		}
+	})
+
+	t.Run("Reject missing key id", func(t *testing.T) {
+		webpush := NewWebPushClient(&clientMock{}, &auth.SimpleJwtSigner{}, &ece.Aes128GcmEncoder{}, WithKeyRing(ring))
+
+		sub := oldSub
+		sub.KeyID = ""
+		if _, err := webpush.Send([]byte("Hello World!"), &WebPushInfo{Subscription: sub}, nil); err == nil {
+			t.Fatal("Subscription without key id was sent with the current key")
+		}
+	})
+
+	t.Run("Remove current key", func(t *testing.T) {
+		if err := ring.Remove("v2"); err == nil {
+			t.Fatal("Current key was removed")
+		}
+
+		if err := ring.Remove("v1"); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
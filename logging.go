@@ -0,0 +1,60 @@
+package webpush
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/url"
+)
+
+type loggerKey struct{}
+
+func contextWithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	if logger == nil {
+		return ctx
+	}
+
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+func loggerFromContext(ctx context.Context) *slog.Logger {
+	logger, _ := ctx.Value(loggerKey{}).(*slog.Logger)
+
+	return logger
+}
+
+func endpointOrigin(endpoint string) string {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return ""
+	}
+
+	return u.Scheme + "://" + u.Host
+}
+
+func (c *WebPushClient) logEndpoint(endpoint string) slog.Attr {
+	if c.logSensitive {
+		return slog.String("endpoint", endpoint)
+	}
+
+	return slog.String("endpoint", endpointOrigin(endpoint)+"/[redacted]")
+}
+
+// RedactError drops the full endpoint that net/http puts into transport
+// errors.
+func RedactError(err error) string {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return urlErr.Op + " " + endpointOrigin(urlErr.URL) + "/[redacted]: " + urlErr.Err.Error()
+	}
+
+	return err.Error()
+}
+
+func (c *WebPushClient) logError(err error) slog.Attr {
+	if c.logSensitive {
+		return slog.String("error", err.Error())
+	}
+
+	return slog.String("error", RedactError(err))
+}
@@ -0,0 +1,75 @@
+package webpush
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Firebain/webpush-go/auth"
+	"github.com/Firebain/webpush-go/ece"
+)
+
+type unreachableClientMock struct{}
+
+func (c *unreachableClientMock) Do(req *http.Request) (*http.Response, error) {
+	return nil, &url.Error{Op: "Post", URL: req.URL.String(), Err: errors.New("connection refused")}
+}
+
+func TestLogging(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	client := clientMock{}
+	webpush := NewWebPushClient(&client, &auth.SimpleJwtSigner{Logger: logger}, &ece.Aes128GcmEncoder{}, WithLogger(logger))
+
+	info := testInfo()
+
+	res, err := webpush.Send([]byte("Hello World!"), info, &WebPushOptions{TTL: 60, Urgency: "high"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	out := buf.String()
+	for _, expected := range []string{
+		`"msg":"webpush send start"`,
+		`"msg":"webpush send finish"`,
+		`"msg":"vapid token signed"`,
+		`"origin":"https://test-ns.com"`,
+		`"status":201`,
+		`"ciphertext_size":`,
+		`"urgency":"high"`,
+	} {
+		if !strings.Contains(out, expected) {
+			t.Fatal("Log doesn't contain", expected, out)
+		}
+	}
+
+	for _, secret := range []string{"ns/token", info.VapidDetails.PrivateKey, info.Subscription.Keys.Auth} {
+		if strings.Contains(out, secret) {
+			t.Fatal("Log leaks", secret)
+		}
+	}
+
+	buf.Reset()
+	failing := NewWebPushClient(&unreachableClientMock{}, &auth.SimpleJwtSigner{}, &ece.Aes128GcmEncoder{}, WithLogger(logger),
+		WithMiddleware(RetryMiddleware(2, time.Millisecond, time.Second, nil)))
+
+	if _, err := failing.Send([]byte("Hello World!"), info, nil); err == nil {
+		t.Fatal("Expected a transport error")
+	}
+
+	out = buf.String()
+	if !strings.Contains(out, `"msg":"webpush send failed"`) || !strings.Contains(out, `"msg":"webpush send retry"`) || !strings.Contains(out, "connection refused") {
+		t.Fatal("Failure was not logged", out)
+	}
+
+	if strings.Contains(out, "ns/token") {
+		t.Fatal("Failure log leaks the endpoint", out)
+	}
+}
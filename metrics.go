@@ -0,0 +1,69 @@
+package webpush
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/Firebain/webpush-go/metrics"
+)
+
+const (
+	metricSendAttempts   = "webpush_send_attempts_total"
+	metricSendResults    = "webpush_send_results_total"
+	metricSendRetries    = "webpush_send_retries_total"
+	metricSendDuration   = "webpush_send_duration_seconds"
+	metricPayloadSize    = "webpush_payload_bytes"
+	metricCiphertextSize = "webpush_ciphertext_bytes"
+	outcomeSuccess       = "success"
+	outcomeGone          = "gone"
+	outcomeRateLimited   = "rate_limited"
+	outcomeClientError   = "client_error"
+	outcomeServerError   = "server_error"
+	outcomeError         = "error"
+	outcomeDeduplicated  = "deduplicated"
+	outcomeInvalid       = "invalid"
+	outcomeDeferred      = "deferred"
+	outcomeDropped       = "dropped"
+)
+
+type metricsKey struct{}
+
+func contextWithMetrics(ctx context.Context, recorder metrics.Recorder) context.Context {
+	if recorder == nil {
+		return ctx
+	}
+
+	return context.WithValue(ctx, metricsKey{}, recorder)
+}
+
+func metricsFromContext(ctx context.Context) metrics.Recorder {
+	recorder, _ := ctx.Value(metricsKey{}).(metrics.Recorder)
+
+	return recorder
+}
+
+func recordOutcome(recorder metrics.Recorder, endpoint string, outcome string) {
+	if recorder == nil {
+		return
+	}
+
+	recorder.Counter(metricSendResults, metrics.Labels{
+		"origin":  endpointOrigin(endpoint),
+		"outcome": outcome,
+	}, 1)
+}
+
+func statusOutcome(status int) string {
+	switch {
+	case status >= 200 && status < 300:
+		return outcomeSuccess
+	case status == http.StatusNotFound || status == http.StatusGone:
+		return outcomeGone
+	case status == http.StatusTooManyRequests:
+		return outcomeRateLimited
+	case status >= 500:
+		return outcomeServerError
+	default:
+		return outcomeClientError
+	}
+}
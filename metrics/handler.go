@@ -0,0 +1,89 @@
+package metrics
+
+import (
+	"bufio"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+		out := bufio.NewWriter(w)
+		defer out.Flush()
+
+		writeSnapshot(out, r.Snapshot())
+	})
+}
+
+func writeSnapshot(out *bufio.Writer, snapshot Snapshot) {
+	typed := make(map[string]bool)
+
+	for _, counter := range snapshot.Counters {
+		if !typed[counter.Name] {
+			out.WriteString("# TYPE " + counter.Name + " counter\n")
+			typed[counter.Name] = true
+		}
+
+		writeSample(out, counter.Name, counter.Labels, "", "", counter.Value)
+	}
+
+	for _, h := range snapshot.Histograms {
+		if !typed[h.Name] {
+			out.WriteString("# TYPE " + h.Name + " histogram\n")
+			typed[h.Name] = true
+		}
+
+		for _, bucket := range h.Buckets {
+			writeSample(out, h.Name+"_bucket", h.Labels, "le", formatFloat(bucket.UpperBound), float64(bucket.Count))
+		}
+
+		writeSample(out, h.Name+"_bucket", h.Labels, "le", "+Inf", float64(h.Count))
+		writeSample(out, h.Name+"_sum", h.Labels, "", "", h.Sum)
+		writeSample(out, h.Name+"_count", h.Labels, "", "", float64(h.Count))
+	}
+}
+
+func writeSample(out *bufio.Writer, name string, labels Labels, extraKey string, extraValue string, value float64) {
+	out.WriteString(name)
+
+	keys := sortedKeys(labels)
+	if len(keys) > 0 || extraKey != "" {
+		out.WriteByte('{')
+
+		for i, key := range keys {
+			if i > 0 {
+				out.WriteByte(',')
+			}
+			writeLabel(out, key, labels[key])
+		}
+
+		if extraKey != "" {
+			if len(keys) > 0 {
+				out.WriteByte(',')
+			}
+			writeLabel(out, extraKey, extraValue)
+		}
+
+		out.WriteByte('}')
+	}
+
+	out.WriteByte(' ')
+	out.WriteString(formatFloat(value))
+	out.WriteByte('\n')
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func writeLabel(out *bufio.Writer, key string, value string) {
+	out.WriteString(key)
+	out.WriteString(`="`)
+	labelEscaper.WriteString(out, value)
+	out.WriteByte('"')
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
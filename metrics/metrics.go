@@ -0,0 +1,200 @@
+package metrics
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+type Labels map[string]string
+
+type Recorder interface {
+	Counter(name string, labels Labels, delta float64)
+	Histogram(name string, labels Labels, value float64)
+}
+
+var (
+	DefaultDurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+	DefaultSizeBuckets     = []float64{64, 256, 512, 1024, 2048, 3072, 4096}
+)
+
+type CounterValue struct {
+	Name   string
+	Labels Labels
+	Value  float64
+}
+
+type Bucket struct {
+	UpperBound float64
+	Count      uint64
+}
+
+type HistogramValue struct {
+	Name    string
+	Labels  Labels
+	Buckets []Bucket
+	Count   uint64
+	Sum     float64
+}
+
+type Snapshot struct {
+	Counters   []CounterValue
+	Histograms []HistogramValue
+}
+
+type histogram struct {
+	name   string
+	labels Labels
+	bounds []float64
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+type Registry struct {
+	mu         sync.Mutex
+	buckets    map[string][]float64
+	counters   map[string]*CounterValue
+	histograms map[string]*histogram
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		buckets:    make(map[string][]float64),
+		counters:   make(map[string]*CounterValue),
+		histograms: make(map[string]*histogram),
+	}
+}
+
+func (r *Registry) SetBuckets(name string, buckets []float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	sorted := append([]float64(nil), buckets...)
+	sort.Float64s(sorted)
+	r.buckets[name] = sorted
+}
+
+func (r *Registry) Counter(name string, labels Labels, delta float64) {
+	key := seriesKey(name, labels)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	counter, ok := r.counters[key]
+	if !ok {
+		counter = &CounterValue{Name: name, Labels: copyLabels(labels)}
+		r.counters[key] = counter
+	}
+
+	counter.Value += delta
+}
+
+func (r *Registry) Histogram(name string, labels Labels, value float64) {
+	key := seriesKey(name, labels)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	h, ok := r.histograms[key]
+	if !ok {
+		bounds := r.bucketsFor(name)
+		h = &histogram{
+			name:   name,
+			labels: copyLabels(labels),
+			bounds: bounds,
+			counts: make([]uint64, len(bounds)),
+		}
+		r.histograms[key] = h
+	}
+
+	for i, bound := range h.bounds {
+		if value <= bound {
+			h.counts[i]++
+		}
+	}
+
+	h.count++
+	h.sum += value
+}
+
+func (r *Registry) Snapshot() Snapshot {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var snapshot Snapshot
+
+	for _, key := range sortedKeys(r.counters) {
+		counter := r.counters[key]
+		snapshot.Counters = append(snapshot.Counters, CounterValue{
+			Name:   counter.Name,
+			Labels: copyLabels(counter.Labels),
+			Value:  counter.Value,
+		})
+	}
+
+	for _, key := range sortedKeys(r.histograms) {
+		h := r.histograms[key]
+
+		buckets := make([]Bucket, len(h.bounds))
+		for i, bound := range h.bounds {
+			buckets[i] = Bucket{UpperBound: bound, Count: h.counts[i]}
+		}
+
+		snapshot.Histograms = append(snapshot.Histograms, HistogramValue{
+			Name:    h.name,
+			Labels:  copyLabels(h.labels),
+			Buckets: buckets,
+			Count:   h.count,
+			Sum:     h.sum,
+		})
+	}
+
+	return snapshot
+}
+
+func (r *Registry) bucketsFor(name string) []float64 {
+	if buckets, ok := r.buckets[name]; ok {
+		return buckets
+	}
+
+	if strings.HasSuffix(name, "_bytes") {
+		return DefaultSizeBuckets
+	}
+
+	return DefaultDurationBuckets
+}
+
+func seriesKey(name string, labels Labels) string {
+	var b strings.Builder
+	b.WriteString(name)
+
+	for _, key := range sortedKeys(labels) {
+		b.WriteByte(0)
+		b.WriteString(key)
+		b.WriteByte('=')
+		b.WriteString(labels[key])
+	}
+
+	return b.String()
+}
+
+func copyLabels(labels Labels) Labels {
+	out := make(Labels, len(labels))
+	for key, value := range labels {
+		out[key] = value
+	}
+
+	return out
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,49 @@
+package metrics
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	registry := NewRegistry()
+	registry.SetBuckets("latency_seconds", []float64{1, 0.1})
+
+	labels := Labels{"origin": "https://fcm.googleapis.com"}
+	registry.Counter("sends_total", labels, 1)
+	registry.Counter("sends_total", Labels{"origin": "https://fcm.googleapis.com"}, 2)
+	registry.Histogram("latency_seconds", labels, 0.05)
+	registry.Histogram("latency_seconds", labels, 0.5)
+
+	snapshot := registry.Snapshot()
+	if len(snapshot.Counters) != 1 || snapshot.Counters[0].Value != 3 {
+		t.Fatalf("Unexpected counters %+v", snapshot.Counters)
+	}
+
+	rec := httptest.NewRecorder()
+	registry.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	body, err := io.ReadAll(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `# TYPE sends_total counter
+sends_total{origin="https://fcm.googleapis.com"} 3
+# TYPE latency_seconds histogram
+latency_seconds_bucket{origin="https://fcm.googleapis.com",le="0.1"} 1
+latency_seconds_bucket{origin="https://fcm.googleapis.com",le="1"} 2
+latency_seconds_bucket{origin="https://fcm.googleapis.com",le="+Inf"} 2
+latency_seconds_sum{origin="https://fcm.googleapis.com"} 0.55
+latency_seconds_count{origin="https://fcm.googleapis.com"} 2
+`
+	if string(body) != expected {
+		t.Fatal("Unexpected exposition", string(body))
+	}
+
+	if !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Fatal("Unexpected content type")
+	}
+}
@@ -0,0 +1,43 @@
+package webpush
+
+import (
+	"testing"
+
+	"github.com/Firebain/webpush-go/auth"
+	"github.com/Firebain/webpush-go/ece"
+	"github.com/Firebain/webpush-go/metrics"
+)
+
+func TestMetrics(t *testing.T) {
+	registry := metrics.NewRegistry()
+	signer := auth.NewCachedJwtSigner(auth.WithCacheMetrics(registry))
+	defer signer.Close()
+
+	client := flakyClientMock{Statuses: []int{201, 410}}
+	webpush := NewWebPushClient(&client, signer, &ece.Aes128GcmEncoder{}, WithMetrics(registry))
+
+	for range client.Statuses {
+		res, err := webpush.Send([]byte("Hello World!"), testInfo(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+	}
+
+	values := map[string]float64{}
+	for _, counter := range registry.Snapshot().Counters {
+		values[counter.Name+"/"+counter.Labels["outcome"]+counter.Labels["result"]] += counter.Value
+	}
+
+	for name, expected := range map[string]float64{
+		"webpush_send_attempts_total/":         2,
+		"webpush_send_results_total/success":   1,
+		"webpush_send_results_total/gone":      1,
+		"webpush_jwt_cache_lookups_total/miss": 1,
+		"webpush_jwt_cache_lookups_total/hit":  1,
+	} {
+		if values[name] != expected {
+			t.Fatal("Unexpected value for", name, values)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package webpush
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/Firebain/webpush-go/clock"
+	"github.com/Firebain/webpush-go/metrics"
+)
+
+// Message has Prepared set instead of Payload when it comes from
+// SendPrepared.
+type Message struct {
+	Payload  []byte
+	Info     *WebPushInfo
+	Options  *WebPushOptions
+	Prepared *PreparedMessage
+}
+
+type SendFunc func(ctx context.Context, msg *Message) (*SendResult, error)
+
+type SendMiddleware func(next SendFunc) SendFunc
+
+// Layer names a stage of the client's send chain for WithLayerOrder.
+type Layer int
+
+const (
+	// LayerMiddleware holds everything passed to WithMiddleware.
+	LayerMiddleware Layer = iota
+	LayerDedup
+	LayerTenants
+	LayerPolicy
+)
+
+var defaultLayers = []Layer{LayerMiddleware, LayerDedup, LayerTenants, LayerPolicy}
+
+func (c *WebPushClient) sendChain() SendFunc {
+	layers := map[Layer]SendMiddleware{
+		LayerMiddleware: chainMiddleware(c.middleware),
+		LayerPolicy:     policyMiddleware(c.counter, c.deferrer, c.clock),
+	}
+
+	if c.tenants != nil {
+		layers[LayerTenants] = c.tenants.middleware(c.clock)
+	}
+
+	if c.dedup != nil {
+		layers[LayerDedup] = DedupMiddleware(c.dedup, c.clock)
+	}
+
+	var order []Layer
+	seen := make(map[Layer]bool)
+	for _, layer := range append(append([]Layer(nil), c.layers...), defaultLayers...) {
+		if !seen[layer] {
+			seen[layer] = true
+			order = append(order, layer)
+		}
+	}
+
+	send := SendFunc(c.deliver)
+	for i := len(order) - 1; i >= 0; i-- {
+		if middleware, ok := layers[order[i]]; ok {
+			send = middleware(send)
+		}
+	}
+
+	return send
+}
+
+func chainMiddleware(middleware []SendMiddleware) SendMiddleware {
+	return func(next SendFunc) SendFunc {
+		for i := len(middleware) - 1; i >= 0; i-- {
+			next = middleware[i](next)
+		}
+
+		return next
+	}
+}
+
+var ErrOriginNotAllowed = errors.New("push service origin is not allowed")
+
+func AllowOrigins(origins ...string) SendMiddleware {
+	allowed := make(map[string]bool, len(origins))
+	for _, origin := range origins {
+		allowed[origin] = true
+	}
+
+	return func(next SendFunc) SendFunc {
+		return func(ctx context.Context, msg *Message) (*SendResult, error) {
+			endpoint, err := url.Parse(msg.Info.Subscription.Endpoint)
+			if err != nil {
+				return nil, err
+			}
+
+			if !allowed[endpoint.Scheme+"://"+endpoint.Host] {
+				return nil, ErrOriginNotAllowed
+			}
+
+			return next(ctx, msg)
+		}
+	}
+}
+
+// RetryMiddleware gives up once the backoff or a Retry-After header asks to
+// wait longer than maxWait. A maxWait of zero leaves the wait uncapped.
+func RetryMiddleware(maxAttempts int, backoff time.Duration, maxWait time.Duration, clk clock.Clock) SendMiddleware {
+	clk = clock.OrSystem(clk)
+
+	return func(next SendFunc) SendFunc {
+		return func(ctx context.Context, msg *Message) (*SendResult, error) {
+			for attempt := 1; ; attempt++ {
+				res, err := next(ctx, msg)
+				if attempt >= maxAttempts || !retryable(res, err) {
+					if res != nil {
+						res.Attempts = attempt
+					}
+
+					return res, err
+				}
+
+				wait := retryBackoff(backoff, attempt, maxWait)
+				if res != nil && res.Response != nil {
+					if after, ok := retryAfter(res.Response.Header.Get("Retry-After"), clk.Now()); ok {
+						wait = after
+					}
+				}
+
+				if maxWait > 0 && wait > maxWait {
+					if res != nil {
+						res.Attempts = attempt
+					}
+
+					return res, err
+				}
+
+				reason := ""
+				if res != nil && res.Response != nil {
+					reason = res.Response.Status
+					res.Response.Body.Close()
+				} else if err != nil {
+					reason = RedactError(err)
+				}
+
+				if recorder := metricsFromContext(ctx); recorder != nil {
+					recorder.Counter(metricSendRetries, metrics.Labels{
+						"origin": endpointOrigin(msg.Info.Subscription.Endpoint),
+					}, 1)
+				}
+
+				if logger := loggerFromContext(ctx); logger != nil {
+					logger.LogAttrs(ctx, slog.LevelInfo, "webpush send retry",
+						slog.String("origin", endpointOrigin(msg.Info.Subscription.Endpoint)),
+						slog.Int("attempt", attempt),
+						slog.Duration("wait", wait),
+						slog.String("reason", reason),
+					)
+				}
+
+				select {
+				case <-ctx.Done():
+					return nil, ctx.Err()
+				case <-clk.After(wait):
+				}
+			}
+		}
+	}
+}
+
+// retryBackoff doubles backoff for every attempt and saturates instead of
+// overflowing.
+func retryBackoff(backoff time.Duration, attempt int, maxWait time.Duration) time.Duration {
+	wait := backoff
+	for i := 1; i < attempt && (maxWait <= 0 || wait <= maxWait); i++ {
+		if wait > math.MaxInt64/2 {
+			return math.MaxInt64
+		}
+
+		wait *= 2
+	}
+
+	return wait
+}
+
+// retryAfter reads a Retry-After header given either in seconds or as an
+// HTTP-date.
+func retryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+
+		if seconds > int64(math.MaxInt64/time.Second) {
+			return math.MaxInt64, true
+		}
+
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	date, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+
+	return max(date.Sub(now), 0), true
+}
+
+func retryable(res *SendResult, err error) bool {
+	if err != nil {
+		var urlErr *url.Error
+		return errors.As(err, &urlErr) && !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+	}
+
+	if res.Response == nil {
+		return false
+	}
+
+	return res.Response.StatusCode == http.StatusTooManyRequests || res.Response.StatusCode >= 500
+}
@@ -0,0 +1,177 @@
+package webpush
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Firebain/webpush-go/auth"
+	"github.com/Firebain/webpush-go/clock"
+	"github.com/Firebain/webpush-go/ece"
+)
+
+type flakyClientMock struct {
+	Statuses []int
+	Header   http.Header
+	Calls    int
+}
+
+func (c *flakyClientMock) Do(req *http.Request) (*http.Response, error) {
+	status := c.Statuses[c.Calls]
+	c.Calls++
+
+	header := http.Header{}
+	if c.Header != nil {
+		header = c.Header.Clone()
+	}
+
+	return &http.Response{
+		StatusCode: status,
+		Header:     header,
+		Body:       io.NopCloser(bytes.NewReader([]byte{})),
+	}, nil
+}
+
+func TestMiddleware(t *testing.T) {
+	t.Run("Ordering", func(t *testing.T) {
+		var calls []string
+		record := func(name string) SendMiddleware {
+			return func(next SendFunc) SendFunc {
+				return func(ctx context.Context, msg *Message) (*SendResult, error) {
+					calls = append(calls, name)
+					return next(ctx, msg)
+				}
+			}
+		}
+
+		client := clientMock{}
+		webpush := NewWebPushClient(&client, &auth.SimpleJwtSigner{}, &ece.Aes128GcmEncoder{}, WithMiddleware(record("outer"), record("inner")))
+
+		res, err := webpush.Send([]byte("Hello World!"), testInfo(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+
+		if len(calls) != 2 || calls[0] != "outer" || calls[1] != "inner" {
+			t.Fatal("Unexpected order", calls)
+		}
+	})
+
+	t.Run("Layer order", func(t *testing.T) {
+		var deduplicated []bool
+		record := func(next SendFunc) SendFunc {
+			return func(ctx context.Context, msg *Message) (*SendResult, error) {
+				res, err := next(ctx, msg)
+				if res != nil {
+					deduplicated = append(deduplicated, res.Deduplicated)
+				}
+				return res, err
+			}
+		}
+
+		client := clientMock{}
+		webpush := NewWebPushClient(&client, &auth.SimpleJwtSigner{}, &ece.Aes128GcmEncoder{},
+			WithDedupStore(NewMemoryDedupStore(0, 0)),
+			WithMiddleware(record),
+			WithLayerOrder(LayerDedup, LayerMiddleware),
+		)
+
+		for range 2 {
+			res, err := webpush.SendWithResult(context.Background(), []byte("Hello World!"), testInfo(), &WebPushOptions{IdempotencyKey: "welcome"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Response != nil {
+				res.Response.Body.Close()
+			}
+		}
+
+		if len(deduplicated) != 1 || deduplicated[0] {
+			t.Fatal("Middleware ran outside the dedup layer", deduplicated)
+		}
+	})
+
+	t.Run("Allow origins", func(t *testing.T) {
+		client := clientMock{}
+		webpush := NewWebPushClient(&client, &auth.SimpleJwtSigner{}, &ece.Aes128GcmEncoder{}, WithMiddleware(AllowOrigins("https://fcm.googleapis.com")))
+
+		_, err := webpush.Send([]byte("Hello World!"), testInfo(), nil)
+		if !errors.Is(err, ErrOriginNotAllowed) || client.Called {
+			t.Fatal("Request to unknown origin was sent", err)
+		}
+	})
+
+	t.Run("Retry", func(t *testing.T) {
+		client := flakyClientMock{Statuses: []int{503, 429, 201}}
+		webpush := NewWebPushClient(&client, &auth.SimpleJwtSigner{}, &ece.Aes128GcmEncoder{}, WithMiddleware(RetryMiddleware(3, time.Millisecond, time.Second, nil)))
+
+		res, err := webpush.SendWithResult(context.Background(), []byte("Hello World!"), testInfo(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Response.Body.Close()
+
+		if res.Response.StatusCode != 201 || res.Attempts != 3 {
+			t.Fatal("Unexpected result", res.Response.StatusCode, res.Attempts)
+		}
+	})
+
+	t.Run("Retry gives up beyond max wait", func(t *testing.T) {
+		client := flakyClientMock{Statuses: []int{503, 503, 503, 503, 201}}
+		webpush := NewWebPushClient(&client, &auth.SimpleJwtSigner{}, &ece.Aes128GcmEncoder{}, WithMiddleware(RetryMiddleware(5, time.Millisecond, 3*time.Millisecond, nil)))
+
+		res, err := webpush.SendWithResult(context.Background(), []byte("Hello World!"), testInfo(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Response.Body.Close()
+
+		if res.Response.StatusCode != 503 || res.Attempts != 3 {
+			t.Fatal("Unexpected result", res.Response.StatusCode, res.Attempts)
+		}
+	})
+
+	t.Run("Retry after HTTP-date without max wait", func(t *testing.T) {
+		fake := clock.NewFake(time.Unix(1710588595, 0))
+		client := flakyClientMock{
+			Statuses: []int{503, 201},
+			Header:   http.Header{"Retry-After": {fake.Now().Add(2 * time.Hour).UTC().Format(http.TimeFormat)}},
+		}
+		webpush := NewWebPushClient(&client, &auth.SimpleJwtSigner{}, &ece.Aes128GcmEncoder{}, WithMiddleware(RetryMiddleware(2, time.Millisecond, 0, fake)))
+
+		done := make(chan *SendResult)
+		go func() {
+			res, err := webpush.SendWithResult(context.Background(), []byte("Hello World!"), testInfo(), nil)
+			if err != nil {
+				t.Error(err)
+			}
+			done <- res
+		}()
+
+		for fake.Waiters() == 0 {
+			time.Sleep(time.Millisecond)
+		}
+
+		fake.Advance(time.Hour)
+		if fake.Waiters() != 1 {
+			t.Fatal("Retry didn't wait for the HTTP-date")
+		}
+
+		fake.Advance(time.Hour)
+
+		res := <-done
+		if res == nil {
+			return
+		}
+		res.Response.Body.Close()
+
+		if res.Response.StatusCode != 201 || res.Attempts != 2 {
+			t.Fatal("Unexpected result", res.Response.StatusCode, res.Attempts)
+		}
+	})
+}
@@ -0,0 +1,67 @@
+package webpush
+
+import (
+	"context"
+)
+
+func CopySubscriptions(ctx context.Context, dst SubscriptionStore, src SubscriptionStore) (int, error) {
+	count := 0
+	var putErr error
+
+	err := src.Range(ctx, func(rec *SubscriptionRecord) bool {
+		if putErr = dst.Put(ctx, rec); putErr != nil {
+			return false
+		}
+
+		count++
+
+		return true
+	})
+	if err != nil {
+		return count, err
+	}
+
+	return count, putErr
+}
+
+// ReassignKeyIDs only relabels subscriptions. An endpoint stays bound to the
+// key it was created with, so retired keys need the browser to resubscribe.
+func ReassignKeyIDs(ctx context.Context, store SubscriptionStore, mapping map[string]string) (int, error) {
+	var changed []*SubscriptionRecord
+
+	err := store.Range(ctx, func(rec *SubscriptionRecord) bool {
+		if to, ok := mapping[rec.Subscription.KeyID]; ok && to != rec.Subscription.KeyID {
+			copied := *rec
+			copied.Subscription.KeyID = to
+			changed = append(changed, &copied)
+		}
+
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for i, rec := range changed {
+		if err := store.Put(ctx, rec); err != nil {
+			return i, err
+		}
+	}
+
+	return len(changed), nil
+}
+
+func StaleSubscriptions(ctx context.Context, store SubscriptionStore, ring *KeyRing, fn func(rec *SubscriptionRecord) bool) error {
+	current, _, err := ring.Current()
+	if err != nil {
+		return err
+	}
+
+	return store.Range(ctx, func(rec *SubscriptionRecord) bool {
+		if rec.Subscription.KeyID == current {
+			return true
+		}
+
+		return fn(rec)
+	})
+}
@@ -0,0 +1,86 @@
+package webpush
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+func TestMigration(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Copy into an encrypted store", func(t *testing.T) {
+		source := testStore(t, 5)
+
+		codec := NewSubscriptionCodec()
+		if err := codec.Add("k1", bytes.Repeat([]byte{1}, 16)); err != nil {
+			t.Fatal(err)
+		}
+
+		backing := NewMemorySubscriptionStore()
+		count, err := CopySubscriptions(ctx, NewEncryptedStore(backing, codec), source)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if count != 5 || backing.Len() != 5 {
+			t.Fatal("Unexpected copy count", count, backing.Len())
+		}
+
+		if err := backing.Range(ctx, func(rec *SubscriptionRecord) bool {
+			if !codec.Stale(rec.Subscription) {
+				return true
+			}
+
+			t.Fatal("Record was not encrypted", rec.ID)
+			return false
+		}); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("Reassign key ids", func(t *testing.T) {
+		store := testStore(t, 3)
+
+		other := testInfo().Subscription
+		other.KeyID = "v9"
+		if err := store.Put(ctx, NewSubscriptionRecord(other)); err != nil {
+			t.Fatal(err)
+		}
+
+		count, err := ReassignKeyIDs(ctx, store, map[string]string{"v1": "prod-1"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if count != 3 {
+			t.Fatal("Unexpected change count", count)
+		}
+
+		if rec, _ := store.Get(ctx, other.ID()); rec.Subscription.KeyID != "v9" {
+			t.Fatal("Unmapped key id was changed")
+		}
+
+		keys, err := GenerateVapidKeys()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ring := NewKeyRing()
+		if err := ring.Add("prod-1", VapidDetails{Subject: "example@push.com", VapidKeys: *keys}); err != nil {
+			t.Fatal(err)
+		}
+
+		var stale []string
+		if err := StaleSubscriptions(ctx, store, ring, func(rec *SubscriptionRecord) bool {
+			stale = append(stale, rec.Subscription.KeyID)
+			return true
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(stale) != 1 || stale[0] != "v9" {
+			t.Fatal("Unexpected stale subscriptions", stale)
+		}
+	})
+}
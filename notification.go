@@ -0,0 +1,224 @@
+package webpush
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"time"
+
+	"github.com/Firebain/webpush-go/ece"
+)
+
+const MaxNotificationActions = 2
+
+type NotificationDir string
+
+const (
+	DirAuto NotificationDir = "auto"
+	DirLTR  NotificationDir = "ltr"
+	DirRTL  NotificationDir = "rtl"
+)
+
+type NotificationAction struct {
+	Action string `json:"action"`
+	Title  string `json:"title"`
+	Icon   string `json:"icon,omitempty"`
+}
+
+type Notification struct {
+	Title              string               `json:"title"`
+	Body               string               `json:"body,omitempty"`
+	Icon               string               `json:"icon,omitempty"`
+	Badge              string               `json:"badge,omitempty"`
+	Image              string               `json:"image,omitempty"`
+	Lang               string               `json:"lang,omitempty"`
+	Dir                NotificationDir      `json:"dir,omitempty"`
+	Tag                string               `json:"tag,omitempty"`
+	Renotify           bool                 `json:"renotify,omitempty"`
+	RequireInteraction bool                 `json:"requireInteraction,omitempty"`
+	Silent             bool                 `json:"silent,omitempty"`
+	Actions            []NotificationAction `json:"actions,omitempty"`
+	Vibrate            []int                `json:"vibrate,omitempty"`
+	Timestamp          int64                `json:"timestamp,omitempty"`
+	Data               any                  `json:"data,omitempty"`
+}
+
+func NewNotification(title string) *Notification {
+	return &Notification{Title: title}
+}
+
+func (n *Notification) WithBody(body string) *Notification {
+	n.Body = body
+	return n
+}
+
+func (n *Notification) WithIcon(icon string) *Notification {
+	n.Icon = icon
+	return n
+}
+
+func (n *Notification) WithBadge(badge string) *Notification {
+	n.Badge = badge
+	return n
+}
+
+func (n *Notification) WithImage(image string) *Notification {
+	n.Image = image
+	return n
+}
+
+func (n *Notification) WithLang(lang string) *Notification {
+	n.Lang = lang
+	return n
+}
+
+func (n *Notification) WithDir(dir NotificationDir) *Notification {
+	n.Dir = dir
+	return n
+}
+
+func (n *Notification) WithTag(tag string, renotify bool) *Notification {
+	n.Tag = tag
+	n.Renotify = renotify
+	return n
+}
+
+func (n *Notification) WithRequireInteraction() *Notification {
+	n.RequireInteraction = true
+	return n
+}
+
+func (n *Notification) WithSilent() *Notification {
+	n.Silent = true
+	return n
+}
+
+func (n *Notification) WithAction(action string, title string, icon string) *Notification {
+	n.Actions = append(n.Actions, NotificationAction{Action: action, Title: title, Icon: icon})
+	return n
+}
+
+func (n *Notification) WithVibrate(pattern ...int) *Notification {
+	n.Vibrate = pattern
+	return n
+}
+
+func (n *Notification) WithTimestamp(t time.Time) *Notification {
+	n.Timestamp = t.UnixMilli()
+	return n
+}
+
+func (n *Notification) WithData(data any) *Notification {
+	n.Data = data
+	return n
+}
+
+func (n *Notification) Validate() error {
+	if n.Title == "" {
+		return errors.New("notification title is empty")
+	}
+
+	links := []string{n.Icon, n.Badge, n.Image}
+	for _, action := range n.Actions {
+		if action.Action == "" || action.Title == "" {
+			return errors.New("notification action needs an action and a title")
+		}
+
+		links = append(links, action.Icon)
+	}
+
+	return notificationFields{
+		links:    links,
+		dir:      n.Dir,
+		tag:      n.Tag,
+		renotify: n.Renotify,
+		silent:   n.Silent,
+		vibrate:  n.Vibrate,
+		actions:  len(n.Actions),
+	}.validate()
+}
+
+// notificationFields holds what Notification and DeclarativeNotification
+// validate the same way.
+type notificationFields struct {
+	links    []string
+	dir      NotificationDir
+	tag      string
+	renotify bool
+	silent   bool
+	vibrate  []int
+	actions  int
+}
+
+func (n notificationFields) validate() error {
+	for _, link := range n.links {
+		if err := validateURL(link); err != nil {
+			return err
+		}
+	}
+
+	switch n.dir {
+	case "", DirAuto, DirLTR, DirRTL:
+	default:
+		return errors.New("invalid notification dir")
+	}
+
+	if n.renotify && n.tag == "" {
+		return errors.New("renotify requires a tag")
+	}
+
+	if n.silent && len(n.vibrate) > 0 {
+		return errors.New("silent notification can't vibrate")
+	}
+
+	for _, duration := range n.vibrate {
+		if duration < 0 {
+			return errors.New("vibrate durations must not be negative")
+		}
+	}
+
+	if n.actions > MaxNotificationActions {
+		return errors.New("too many notification actions")
+	}
+
+	return nil
+}
+
+func (n *Notification) MarshalPayload() ([]byte, error) {
+	return n.marshal(ece.DefaultBlockSize)
+}
+
+func (n *Notification) CheckSize(padSize int) error {
+	_, err := n.marshal(padSize)
+
+	return err
+}
+
+func (n *Notification) marshal(padSize int) ([]byte, error) {
+	if err := n.Validate(); err != nil {
+		return nil, err
+	}
+
+	data, err := json.Marshal(n)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkPayloadSize(data, padSize); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func validateURL(link string) error {
+	if link == "" {
+		return nil
+	}
+
+	if _, err := url.Parse(link); err != nil {
+		return errors.New("invalid notification url: " + link)
+	}
+
+	return nil
+}
@@ -0,0 +1,76 @@
+package webpush
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Firebain/webpush-go/auth"
+	"github.com/Firebain/webpush-go/ece"
+)
+
+func TestNotification(t *testing.T) {
+	t.Run("Marshal", func(t *testing.T) {
+		data, err := NewNotification("Order shipped").
+			WithBody("Your order is on its way").
+			WithIcon("https://example.com/icon.png").
+			WithTag("order-42", true).
+			WithAction("track", "Track", "").
+			WithData(map[string]string{"url": "/orders/42"}).
+			WithTimestamp(time.UnixMilli(1710588595000)).
+			MarshalPayload()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var decoded map[string]any
+		if err := json.Unmarshal(data, &decoded); err != nil {
+			t.Fatal(err)
+		}
+
+		if decoded["title"] != "Order shipped" || decoded["renotify"] != true || decoded["timestamp"] != float64(1710588595000) {
+			t.Fatal("Unexpected payload", string(data))
+		}
+	})
+
+	t.Run("Validate", func(t *testing.T) {
+		for _, n := range []*Notification{
+			NewNotification(""),
+			NewNotification("Hi").WithTag("", true),
+			NewNotification("Hi").WithSilent().WithVibrate(100),
+			NewNotification("Hi").WithAction("a", "A", "").WithAction("b", "B", "").WithAction("c", "C", ""),
+			NewNotification("Hi").WithAction("", "A", ""),
+			NewNotification("Hi").WithDir("up"),
+		} {
+			if _, err := n.MarshalPayload(); err == nil {
+				t.Fatalf("Invalid notification was accepted %+v", n)
+			}
+		}
+	})
+
+	t.Run("Size", func(t *testing.T) {
+		n := NewNotification("Hi").WithBody(strings.Repeat("x", 3960))
+
+		if err := n.CheckSize(0); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := n.CheckSize(ece.DefaultBlockSize); !errors.Is(err, ece.ErrPayloadTooLarge) {
+			t.Fatal("Oversized payload was accepted", err)
+		}
+	})
+
+	t.Run("Send", func(t *testing.T) {
+		client := clientMock{}
+		webpush := NewWebPushClient(&client, &auth.SimpleJwtSigner{}, &ece.Aes128GcmEncoder{})
+
+		res, err := webpush.SendPayload(context.Background(), NewNotification("Hi"), testInfo(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+	})
+}
@@ -0,0 +1,92 @@
+package webpush
+
+import (
+	"log/slog"
+
+	"github.com/Firebain/webpush-go/clock"
+	"github.com/Firebain/webpush-go/metrics"
+)
+
+type ClientOption func(*WebPushClient)
+
+func WithKeyRing(ring *KeyRing) ClientOption {
+	return func(c *WebPushClient) {
+		c.keys = ring
+		c.keySources++
+	}
+}
+
+func WithClock(clk clock.Clock) ClientOption {
+	return func(c *WebPushClient) {
+		c.clock = clk
+	}
+}
+
+func WithDedupStore(store DedupStore) ClientOption {
+	return func(c *WebPushClient) {
+		c.dedup = store
+	}
+}
+
+func WithReceiptTracker(tracker *ReceiptTracker) ClientOption {
+	return func(c *WebPushClient) {
+		c.receipts = tracker
+	}
+}
+
+func WithMiddleware(middleware ...SendMiddleware) ClientOption {
+	return func(c *WebPushClient) {
+		c.middleware = append(c.middleware, middleware...)
+	}
+}
+
+// WithLayerOrder sets the send chain outermost first. Layers left out follow
+// the listed ones in their default order.
+func WithLayerOrder(order ...Layer) ClientOption {
+	return func(c *WebPushClient) {
+		c.layers = order
+	}
+}
+
+func WithLogger(logger *slog.Logger) ClientOption {
+	return func(c *WebPushClient) {
+		c.logger = logger
+	}
+}
+
+func WithSensitiveLogging() ClientOption {
+	return func(c *WebPushClient) {
+		c.logSensitive = true
+	}
+}
+
+func WithMetrics(recorder metrics.Recorder) ClientOption {
+	return func(c *WebPushClient) {
+		c.metrics = recorder
+	}
+}
+
+func WithTenantRegistry(registry *TenantRegistry) ClientOption {
+	return func(c *WebPushClient) {
+		c.tenants = registry
+	}
+}
+
+func WithKeyProvider(provider KeyProvider) ClientOption {
+	return func(c *WebPushClient) {
+		c.keys = provider
+		c.keySources++
+	}
+}
+
+func WithDeliveryCounter(counter DeliveryCounter) ClientOption {
+	return func(c *WebPushClient) {
+		c.counter = counter
+	}
+}
+
+func WithDeferrer(deferrer Deferrer) ClientOption {
+	return func(c *WebPushClient) {
+		c.deferrer = deferrer
+	}
+}
@@ -0,0 +1,129 @@
+package outbox
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	webpush "github.com/Firebain/webpush-go"
+)
+
+const (
+	opEnqueue = "enqueue"
+	opRetry   = "retry"
+	opDone    = "done"
+	opExpired = "expired"
+	opDead    = "dead"
+)
+
+type record struct {
+	Op          string                   `json:"op"`
+	ID          string                   `json:"id"`
+	Message     *webpush.PreparedMessage `json:"message,omitempty"`
+	Attempts    int                      `json:"attempts,omitempty"`
+	NextAttempt time.Time                `json:"nextAttempt,omitempty"`
+	Reason      string                   `json:"reason,omitempty"`
+}
+
+type logFile struct {
+	path string
+	file *os.File
+}
+
+func openLog(path string) (*logFile, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	return &logFile{path: path, file: file}, nil
+}
+
+func (l *logFile) replay(apply func(*record)) error {
+	if _, err := l.file.Seek(0, 0); err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(l.file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	for scanner.Scan() {
+		var rec record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			// A torn write from a crash can only be the last line.
+			continue
+		}
+
+		apply(&rec)
+	}
+
+	return scanner.Err()
+}
+
+func (l *logFile) append(rec *record) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	if _, err := l.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+
+	return l.file.Sync()
+}
+
+func (l *logFile) rewrite(records []*record) error {
+	tmp, err := os.CreateTemp(filepath.Dir(l.path), filepath.Base(l.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	writer := bufio.NewWriter(tmp)
+	for _, rec := range records {
+		data, err := json.Marshal(rec)
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+
+		writer.Write(data)
+		writer.WriteByte('\n')
+	}
+
+	if err := writer.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), l.path); err != nil {
+		return err
+	}
+
+	l.file.Close()
+
+	file, err := os.OpenFile(l.path, os.O_RDWR|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+
+	l.file = file
+
+	return nil
+}
+
+func (l *logFile) close() error {
+	return l.file.Close()
+}
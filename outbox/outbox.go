@@ -0,0 +1,466 @@
+package outbox
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	webpush "github.com/Firebain/webpush-go"
+	"github.com/Firebain/webpush-go/clock"
+)
+
+const (
+	DefaultWorkers     = 4
+	DefaultMaxAttempts = 8
+	DefaultBackoff     = 10 * time.Second
+	DefaultMaxBackoff  = time.Hour
+	// DefaultCompactAfter is the number of finished entries after which the
+	// log is rewritten.
+	DefaultCompactAfter = 1000
+)
+
+type Sender interface {
+	SendPrepared(ctx context.Context, msg *webpush.PreparedMessage) (*http.Response, error)
+}
+
+type Options struct {
+	Workers      int
+	MaxAttempts  int
+	Backoff      time.Duration
+	MaxBackoff   time.Duration
+	CompactAfter int
+	Clock        clock.Clock
+	Logger       *slog.Logger
+}
+
+type Entry struct {
+	ID          string
+	Message     *webpush.PreparedMessage
+	Attempts    int
+	NextAttempt time.Time
+	Reason      string
+
+	inFlight bool
+}
+
+type Outbox struct {
+	sender       Sender
+	workers      int
+	maxAttempts  int
+	backoff      time.Duration
+	maxBackoff   time.Duration
+	compactAfter int
+	clock        clock.Clock
+	logger       *slog.Logger
+
+	mu       sync.Mutex
+	log      *logFile
+	finished int
+	pending  map[string]*Entry
+	order    []string
+	dead     map[string]*Entry
+	notify   chan struct{}
+}
+
+func Open(path string, sender Sender, options *Options) (*Outbox, error) {
+	o := &Outbox{
+		sender:       sender,
+		workers:      DefaultWorkers,
+		maxAttempts:  DefaultMaxAttempts,
+		backoff:      DefaultBackoff,
+		maxBackoff:   DefaultMaxBackoff,
+		compactAfter: DefaultCompactAfter,
+		clock:        clock.System{},
+		pending:      make(map[string]*Entry),
+		dead:         make(map[string]*Entry),
+		notify:       make(chan struct{}, 1),
+	}
+
+	if options != nil {
+		if options.Workers > 0 {
+			o.workers = options.Workers
+		}
+
+		if options.MaxAttempts > 0 {
+			o.maxAttempts = options.MaxAttempts
+		}
+
+		if options.Backoff > 0 {
+			o.backoff = options.Backoff
+		}
+
+		if options.MaxBackoff > 0 {
+			o.maxBackoff = options.MaxBackoff
+		}
+
+		if options.CompactAfter > 0 {
+			o.compactAfter = options.CompactAfter
+		}
+
+		if options.Clock != nil {
+			o.clock = options.Clock
+		}
+
+		o.logger = options.Logger
+	}
+
+	log, err := openLog(path)
+	if err != nil {
+		return nil, err
+	}
+
+	o.log = log
+
+	if err := log.replay(o.apply); err != nil {
+		log.close()
+		return nil, err
+	}
+
+	if err := o.Compact(); err != nil {
+		log.close()
+		return nil, err
+	}
+
+	return o, nil
+}
+
+func (o *Outbox) Enqueue(msg *webpush.PreparedMessage) (string, error) {
+	id, err := newID()
+	if err != nil {
+		return "", err
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	rec := &record{Op: opEnqueue, ID: id, Message: msg}
+	if err := o.log.append(rec); err != nil {
+		return "", err
+	}
+
+	o.apply(rec)
+	o.wake()
+
+	return id, nil
+}
+
+func (o *Outbox) Run(ctx context.Context) error {
+	jobs := make(chan *Entry)
+
+	var wg sync.WaitGroup
+	for range o.workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for entry := range jobs {
+				o.deliver(ctx, entry)
+			}
+		}()
+	}
+
+	defer func() {
+		close(jobs)
+		wg.Wait()
+	}()
+
+	for {
+		entry, wait := o.next()
+		if entry != nil {
+			select {
+			case jobs <- entry:
+				continue
+			case <-ctx.Done():
+				o.release(entry)
+				return ctx.Err()
+			}
+		}
+
+		var timer <-chan time.Time
+		if wait > 0 {
+			timer = o.clock.After(wait)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-o.notify:
+		case <-timer:
+		}
+	}
+}
+
+func (o *Outbox) Pending() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return len(o.pending)
+}
+
+func (o *Outbox) DeadLetters() []Entry {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	entries := make([]Entry, 0, len(o.dead))
+	for _, entry := range o.dead {
+		entries = append(entries, *entry)
+	}
+
+	return entries
+}
+
+func (o *Outbox) DropDeadLetter(id string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if _, ok := o.dead[id]; !ok {
+		return errors.New("unknown dead letter")
+	}
+
+	rec := &record{Op: opDone, ID: id}
+	if err := o.log.append(rec); err != nil {
+		return err
+	}
+
+	o.apply(rec)
+
+	return nil
+}
+
+func (o *Outbox) Compact() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return o.compact()
+}
+
+func (o *Outbox) compact() error {
+	records := make([]*record, 0, len(o.order)+len(o.dead))
+	for _, id := range o.order {
+		entry := o.pending[id]
+		records = append(records, &record{
+			Op:          opEnqueue,
+			ID:          id,
+			Message:     entry.Message,
+			Attempts:    entry.Attempts,
+			NextAttempt: entry.NextAttempt,
+		})
+	}
+
+	for id, entry := range o.dead {
+		records = append(records,
+			&record{Op: opEnqueue, ID: id, Message: entry.Message, Attempts: entry.Attempts},
+			&record{Op: opDead, ID: id, Attempts: entry.Attempts, Reason: entry.Reason},
+		)
+	}
+
+	if err := o.log.rewrite(records); err != nil {
+		return err
+	}
+
+	o.finished = 0
+
+	return nil
+}
+
+func (o *Outbox) Close() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return o.log.close()
+}
+
+func (o *Outbox) apply(rec *record) {
+	switch rec.Op {
+	case opEnqueue:
+		if rec.Message == nil {
+			return
+		}
+
+		o.pending[rec.ID] = &Entry{
+			ID:          rec.ID,
+			Message:     rec.Message,
+			Attempts:    rec.Attempts,
+			NextAttempt: rec.NextAttempt,
+		}
+		o.order = append(o.order, rec.ID)
+	case opRetry:
+		if entry, ok := o.pending[rec.ID]; ok {
+			entry.Attempts = rec.Attempts
+			entry.NextAttempt = rec.NextAttempt
+			entry.Reason = rec.Reason
+		}
+	case opDone, opExpired:
+		o.remove(rec.ID)
+		delete(o.dead, rec.ID)
+	case opDead:
+		if entry, ok := o.pending[rec.ID]; ok {
+			o.remove(rec.ID)
+			entry.Attempts = rec.Attempts
+			entry.Reason = rec.Reason
+			o.dead[rec.ID] = entry
+		}
+	}
+}
+
+func (o *Outbox) remove(id string) {
+	if _, ok := o.pending[id]; !ok {
+		return
+	}
+
+	delete(o.pending, id)
+	for i, pendingID := range o.order {
+		if pendingID == id {
+			o.order = append(o.order[:i], o.order[i+1:]...)
+			break
+		}
+	}
+}
+
+func (o *Outbox) next() (*Entry, time.Duration) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	now := o.clock.Now()
+
+	var wait time.Duration
+	for _, id := range append([]string(nil), o.order...) {
+		entry := o.pending[id]
+		if entry.inFlight {
+			continue
+		}
+
+		if entry.Message.Expired(now) {
+			o.finish(&record{Op: opExpired, ID: id})
+			continue
+		}
+
+		if delay := entry.NextAttempt.Sub(now); delay > 0 {
+			if wait == 0 || delay < wait {
+				wait = delay
+			}
+			continue
+		}
+
+		entry.inFlight = true
+
+		return entry, 0
+	}
+
+	return nil, wait
+}
+
+func (o *Outbox) release(entry *Entry) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	entry.inFlight = false
+}
+
+func (o *Outbox) deliver(ctx context.Context, entry *Entry) {
+	res, err := o.sender.SendPrepared(ctx, entry.Message)
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	defer o.wake()
+
+	entry.inFlight = false
+	attempts := entry.Attempts + 1
+
+	var retryAfter time.Duration
+	var reason string
+
+	switch {
+	case errors.Is(err, webpush.ErrMessageExpired):
+		o.finish(&record{Op: opExpired, ID: entry.ID})
+		return
+	case errors.Is(err, webpush.ErrVapidTokenExpired):
+		o.finish(&record{Op: opDead, ID: entry.ID, Attempts: attempts, Reason: webpush.RedactError(err)})
+		return
+	case err != nil:
+		if ctx.Err() != nil {
+			return
+		}
+		reason = webpush.RedactError(err)
+	default:
+		res.Body.Close()
+
+		switch {
+		case res.StatusCode >= 200 && res.StatusCode < 300:
+			o.finish(&record{Op: opDone, ID: entry.ID})
+			return
+		case res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500:
+			reason = res.Status
+			if seconds, err := strconv.Atoi(res.Header.Get("Retry-After")); err == nil {
+				retryAfter = time.Duration(seconds) * time.Second
+			}
+		default:
+			o.finish(&record{Op: opDead, ID: entry.ID, Attempts: attempts, Reason: res.Status})
+			return
+		}
+	}
+
+	if attempts >= o.maxAttempts {
+		o.finish(&record{Op: opDead, ID: entry.ID, Attempts: attempts, Reason: reason})
+		return
+	}
+
+	if retryAfter == 0 {
+		retryAfter = o.backoff << (attempts - 1)
+		if retryAfter > o.maxBackoff || retryAfter <= 0 {
+			retryAfter = o.maxBackoff
+		}
+	}
+
+	o.finish(&record{
+		Op:          opRetry,
+		ID:          entry.ID,
+		Attempts:    attempts,
+		NextAttempt: o.clock.Now().Add(retryAfter),
+		Reason:      reason,
+	})
+}
+
+func (o *Outbox) finish(rec *record) {
+	if o.logger != nil && rec.Op != opDone {
+		o.logger.LogAttrs(context.Background(), slog.LevelInfo, "outbox "+rec.Op,
+			slog.String("id", rec.ID),
+			slog.Int("attempts", rec.Attempts),
+			slog.String("reason", rec.Reason),
+		)
+	}
+
+	// The in-memory state moves on even if the log write fails; the entry is
+	// then replayed from its last durable state after a restart.
+	o.log.append(rec)
+	o.apply(rec)
+
+	if rec.Op != opRetry {
+		o.finished++
+		if o.finished >= o.compactAfter {
+			o.compact()
+		}
+	}
+}
+
+func (o *Outbox) wake() {
+	select {
+	case o.notify <- struct{}{}:
+	default:
+	}
+}
+
+func newID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,213 @@
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	webpush "github.com/Firebain/webpush-go"
+	"github.com/Firebain/webpush-go/clock"
+)
+
+type senderMock struct {
+	mu       sync.Mutex
+	statuses []int
+	calls    int
+}
+
+func (s *senderMock) SendPrepared(ctx context.Context, msg *webpush.PreparedMessage) (*http.Response, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status := s.statuses[s.calls%len(s.statuses)]
+	s.calls++
+
+	return &http.Response{
+		StatusCode: status,
+		Status:     http.StatusText(status),
+		Header:     http.Header{},
+		Body:       io.NopCloser(bytes.NewReader([]byte{})),
+	}, nil
+}
+
+func (s *senderMock) Calls() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.calls
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("Condition not met in time")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func testMessage(expires time.Time) *webpush.PreparedMessage {
+	return &webpush.PreparedMessage{
+		Endpoint: "https://test-ns.com/ns/token",
+		Headers:  http.Header{"Ttl": {"60"}},
+		Body:     []byte("encrypted"),
+		Expires:  expires,
+	}
+}
+
+func TestOutbox(t *testing.T) {
+	start := time.Unix(1710588595, 0)
+
+	t.Run("Retry until delivered", func(t *testing.T) {
+		fake := clock.NewFake(start)
+		sender := &senderMock{statuses: []int{503, 201}}
+
+		box, err := Open(filepath.Join(t.TempDir(), "outbox.log"), sender, &Options{Clock: fake})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer box.Close()
+
+		if _, err := box.Enqueue(testMessage(start.Add(time.Hour))); err != nil {
+			t.Fatal(err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			box.Run(ctx)
+			close(done)
+		}()
+		defer func() {
+			cancel()
+			<-done
+		}()
+
+		waitFor(t, func() bool { return sender.Calls() == 1 && fake.Waiters() > 0 })
+		fake.Advance(DefaultBackoff)
+		waitFor(t, func() bool { return box.Pending() == 0 })
+
+		if sender.Calls() != 2 {
+			t.Fatal("Unexpected number of attempts", sender.Calls())
+		}
+	})
+
+	t.Run("Resume and dead letter", func(t *testing.T) {
+		fake := clock.NewFake(start)
+		path := filepath.Join(t.TempDir(), "outbox.log")
+
+		box, err := Open(path, &senderMock{}, &Options{Clock: fake})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := box.Enqueue(testMessage(start.Add(time.Hour))); err != nil {
+			t.Fatal(err)
+		}
+		box.Close()
+
+		sender := &senderMock{statuses: []int{410}}
+		box, err = Open(path, sender, &Options{Clock: fake})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if box.Pending() != 1 {
+			t.Fatal("Message was not resumed")
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			box.Run(ctx)
+			close(done)
+		}()
+
+		waitFor(t, func() bool { return len(box.DeadLetters()) == 1 })
+		cancel()
+		<-done
+		box.Close()
+
+		box, err = Open(path, sender, &Options{Clock: fake})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer box.Close()
+
+		dead := box.DeadLetters()
+		if len(dead) != 1 || box.Pending() != 0 {
+			t.Fatal("Dead letter was not persisted")
+		}
+
+		if err := box.DropDeadLetter(dead[0].ID); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("Drop expired messages", func(t *testing.T) {
+		fake := clock.NewFake(start)
+		sender := &senderMock{statuses: []int{201}}
+
+		box, err := Open(filepath.Join(t.TempDir(), "outbox.log"), sender, &Options{Clock: fake})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer box.Close()
+
+		if _, err := box.Enqueue(testMessage(start.Add(-time.Second))); err != nil {
+			t.Fatal(err)
+		}
+
+		if entry, _ := box.next(); entry != nil {
+			t.Fatal("Expired message was scheduled")
+		}
+
+		if box.Pending() != 0 || sender.Calls() != 0 {
+			t.Fatal("Expired message was not dropped")
+		}
+	})
+
+	t.Run("Compact after finished entries", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "outbox.log")
+		sender := &senderMock{statuses: []int{201}}
+
+		box, err := Open(path, sender, &Options{Clock: clock.NewFake(start), CompactAfter: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer box.Close()
+
+		for range 3 {
+			if _, err := box.Enqueue(testMessage(start.Add(time.Hour))); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			box.Run(ctx)
+			close(done)
+		}()
+
+		waitFor(t, func() bool { return box.Pending() == 0 })
+		cancel()
+		<-done
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if lines := bytes.Count(data, []byte("\n")); lines > 2 {
+			t.Fatal("Log was not compacted", lines)
+		}
+	})
+}
@@ -0,0 +1,29 @@
+package webpush
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/Firebain/webpush-go/ece"
+)
+
+type Payload interface {
+	MarshalPayload() ([]byte, error)
+}
+
+func (c *WebPushClient) SendPayload(ctx context.Context, payload Payload, info *WebPushInfo, options *WebPushOptions) (*http.Response, error) {
+	data, err := payload.MarshalPayload()
+	if err != nil {
+		return nil, err
+	}
+
+	return c.SendWithContext(ctx, data, info, options)
+}
+
+func checkPayloadSize(data []byte, padSize int) error {
+	if ece.EncryptedSize(len(data), padSize) > ece.MaxMessageSize {
+		return ece.ErrPayloadTooLarge
+	}
+
+	return nil
+}
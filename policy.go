@@ -0,0 +1,360 @@
+package webpush
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/Firebain/webpush-go/clock"
+)
+
+type PolicyAction string
+
+const (
+	PolicyDeliver   PolicyAction = "deliver"
+	PolicyDefer     PolicyAction = "defer"
+	PolicyDowngrade PolicyAction = "downgrade"
+	PolicyDrop      PolicyAction = "drop"
+)
+
+const (
+	ReasonQuietHours = "quiet_hours"
+	ReasonDailyLimit = "daily_limit"
+	ReasonExpired    = "expired"
+)
+
+// TimeOfDay is a wall clock time encoded as "HH:MM" in JSON.
+type TimeOfDay struct {
+	Hour   int
+	Minute int
+}
+
+func (t TimeOfDay) String() string {
+	return fmt.Sprintf("%02d:%02d", t.Hour, t.Minute)
+}
+
+func (t TimeOfDay) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+func (t *TimeOfDay) UnmarshalText(text []byte) error {
+	parsed, err := time.Parse("15:04", string(text))
+	if err != nil {
+		return errors.New("time of day must be formatted as HH:MM")
+	}
+
+	t.Hour, t.Minute = parsed.Hour(), parsed.Minute()
+
+	return nil
+}
+
+func (t TimeOfDay) minutes() int {
+	return t.Hour*60 + t.Minute
+}
+
+// DeliveryPolicy quiet hours may wrap around midnight and are off when start
+// equals end.
+type DeliveryPolicy struct {
+	Timezone    string       `json:"timezone,omitempty"`
+	QuietStart  TimeOfDay    `json:"quietStart"`
+	QuietEnd    TimeOfDay    `json:"quietEnd"`
+	QuietAction PolicyAction `json:"quietAction,omitempty"`
+	MaxPerDay   int          `json:"maxPerDay,omitempty"`
+}
+
+func (p *DeliveryPolicy) Validate() error {
+	if _, err := loadLocation(p.Timezone); err != nil {
+		return err
+	}
+
+	for _, t := range []TimeOfDay{p.QuietStart, p.QuietEnd} {
+		if t.Hour < 0 || t.Hour > 23 || t.Minute < 0 || t.Minute > 59 {
+			return fmt.Errorf("invalid time of day %s", t)
+		}
+	}
+
+	switch p.QuietAction {
+	case "", PolicyDefer, PolicyDowngrade, PolicyDrop:
+	default:
+		return fmt.Errorf("invalid quiet hours action %q", p.QuietAction)
+	}
+
+	if p.MaxPerDay < 0 {
+		return errors.New("max per day must not be negative")
+	}
+
+	return nil
+}
+
+func (p *DeliveryPolicy) quietUntil(now time.Time, loc *time.Location) (time.Time, bool) {
+	start, end := p.QuietStart.minutes(), p.QuietEnd.minutes()
+	if start == end {
+		return time.Time{}, false
+	}
+
+	local := now.In(loc)
+	minute := local.Hour()*60 + local.Minute()
+
+	quiet := start <= minute && minute < end
+	if start > end {
+		quiet = minute >= start || minute < end
+	}
+
+	if !quiet {
+		return time.Time{}, false
+	}
+
+	until := time.Date(local.Year(), local.Month(), local.Day(), p.QuietEnd.Hour, p.QuietEnd.Minute, 0, 0, loc)
+	if !until.After(local) {
+		until = time.Date(local.Year(), local.Month(), local.Day()+1, p.QuietEnd.Hour, p.QuietEnd.Minute, 0, 0, loc)
+	}
+
+	return until, true
+}
+
+// PolicyDecision.TTL is what a deferred message is sent with at Until.
+type PolicyDecision struct {
+	Action PolicyAction
+	Reason string
+	Until  time.Time
+	TTL    int
+}
+
+// Deferrer sends messages held back by a policy later. It must reduce the
+// TTL by the time waited; schedule.Scheduler implements it.
+type Deferrer interface {
+	Schedule(ctx context.Context, key string, at time.Time, payload []byte, info *WebPushInfo, options *WebPushOptions) error
+}
+
+// DeliveryCounter.Release undoes a reservation whose send failed.
+type DeliveryCounter interface {
+	Reserve(key string, day string, limit int) bool
+	Release(key string, day string)
+}
+
+type deliveryCount struct {
+	day   string
+	count int
+}
+
+// MemoryDeliveryCounter forgets a key once its day is more than a day behind
+// the latest day reserved, which is past in every timezone.
+type MemoryDeliveryCounter struct {
+	mu     sync.Mutex
+	counts map[string]deliveryCount
+	latest string
+}
+
+func NewMemoryDeliveryCounter() *MemoryDeliveryCounter {
+	return &MemoryDeliveryCounter{
+		counts: make(map[string]deliveryCount),
+	}
+}
+
+func (c *MemoryDeliveryCounter) Reserve(key string, day string, limit int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if day > c.latest {
+		c.prune(day)
+	}
+
+	entry := c.counts[key]
+	if entry.day != day {
+		entry = deliveryCount{day: day}
+	}
+
+	if entry.count >= limit {
+		return false
+	}
+
+	entry.count++
+	c.counts[key] = entry
+
+	return true
+}
+
+func (c *MemoryDeliveryCounter) prune(day string) {
+	c.latest = day
+
+	parsed, err := time.Parse("2006-01-02", day)
+	if err != nil {
+		return
+	}
+
+	cutoff := parsed.AddDate(0, 0, -1).Format("2006-01-02")
+	for key, entry := range c.counts {
+		if entry.day < cutoff {
+			delete(c.counts, key)
+		}
+	}
+}
+
+func (c *MemoryDeliveryCounter) Release(key string, day string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.counts[key]
+	if !ok || entry.day != day || entry.count == 0 {
+		return
+	}
+
+	entry.count--
+	c.counts[key] = entry
+}
+
+var (
+	ErrMessageDeferred = errors.New("message deferred by delivery policy")
+	ErrMessageDropped  = errors.New("message dropped by delivery policy")
+)
+
+var locations sync.Map
+
+func loadLocation(name string) (*time.Location, error) {
+	if cached, ok := locations.Load(name); ok {
+		return cached.(*time.Location), nil
+	}
+
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, err
+	}
+
+	locations.Store(name, loc)
+
+	return loc, nil
+}
+
+func deferralKey(msg *Message) (string, error) {
+	scope := "policy:" + msg.Info.Subscription.ID() + ":"
+	if msg.Options != nil && msg.Options.IdempotencyKey != "" {
+		return scope + msg.Options.IdempotencyKey, nil
+	}
+
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+
+	return scope + hex.EncodeToString(random), nil
+}
+
+func policyMiddleware(counter DeliveryCounter, deferrer Deferrer, clk clock.Clock) SendMiddleware {
+	return func(next SendFunc) SendFunc {
+		return func(ctx context.Context, msg *Message) (*SendResult, error) {
+			policy := msg.Info.Policy
+			if policy == nil {
+				return next(ctx, msg)
+			}
+
+			loc, err := loadLocation(policy.Timezone)
+			if err != nil {
+				return nil, err
+			}
+
+			now := clk.Now()
+			decision := &PolicyDecision{Action: PolicyDeliver}
+
+			if until, quiet := policy.quietUntil(now, loc); quiet {
+				decision.Reason = ReasonQuietHours
+
+				switch policy.QuietAction {
+				case PolicyDrop:
+					return dropped(ctx, msg, decision), nil
+				case PolicyDowngrade:
+					options := WebPushOptions{TTL: DefaultTTL}
+					if msg.Options != nil {
+						options = *msg.Options
+					}
+
+					options.Urgency = "very-low"
+					msg.Options = &options
+					decision.Action = PolicyDowngrade
+				default:
+					return deferMessage(ctx, msg, decision, deferrer, now, until)
+				}
+			}
+
+			var day string
+			if policy.MaxPerDay > 0 {
+				day = now.In(loc).Format("2006-01-02")
+
+				if !counter.Reserve(msg.Info.Subscription.ID(), day, policy.MaxPerDay) {
+					decision.Reason = ReasonDailyLimit
+					return dropped(ctx, msg, decision), nil
+				}
+			}
+
+			res, err := next(ctx, msg)
+
+			if day != "" && (err != nil || res == nil || res.Response == nil || res.Response.StatusCode >= 300) {
+				counter.Release(msg.Info.Subscription.ID(), day)
+			}
+
+			if res != nil {
+				res.Policy = decision
+			}
+
+			return res, err
+		}
+	}
+}
+
+func dropped(ctx context.Context, msg *Message, decision *PolicyDecision) *SendResult {
+	decision.Action = PolicyDrop
+
+	recordOutcome(metricsFromContext(ctx), msg.Info.Subscription.Endpoint, outcomeDropped)
+
+	if logger := loggerFromContext(ctx); logger != nil {
+		logger.LogAttrs(ctx, slog.LevelInfo, "webpush message dropped by policy",
+			slog.String("origin", endpointOrigin(msg.Info.Subscription.Endpoint)),
+			slog.String("reason", decision.Reason),
+		)
+	}
+
+	return &SendResult{Policy: decision}
+}
+
+func deferMessage(ctx context.Context, msg *Message, decision *PolicyDecision, deferrer Deferrer, now time.Time, until time.Time) (*SendResult, error) {
+	options := WebPushOptions{TTL: DefaultTTL}
+	if msg.Options != nil {
+		options = *msg.Options
+	}
+
+	decision.TTL = options.TTL - int(until.Sub(now)/time.Second)
+	if decision.TTL <= 0 {
+		decision.TTL = 0
+		decision.Reason = ReasonExpired
+		return dropped(ctx, msg, decision), nil
+	}
+
+	decision.Action = PolicyDefer
+	decision.Until = until
+
+	if deferrer != nil {
+		key, err := deferralKey(msg)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := deferrer.Schedule(ctx, key, until, msg.Payload, msg.Info, &options); err != nil {
+			return nil, err
+		}
+	}
+
+	recordOutcome(metricsFromContext(ctx), msg.Info.Subscription.Endpoint, outcomeDeferred)
+
+	if logger := loggerFromContext(ctx); logger != nil {
+		logger.LogAttrs(ctx, slog.LevelInfo, "webpush message deferred by policy",
+			slog.String("origin", endpointOrigin(msg.Info.Subscription.Endpoint)),
+			slog.Time("until", until),
+		)
+	}
+
+	return &SendResult{Policy: decision}, nil
+}
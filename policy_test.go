@@ -0,0 +1,252 @@
+package webpush
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/Firebain/webpush-go/auth"
+	"github.com/Firebain/webpush-go/clock"
+	"github.com/Firebain/webpush-go/ece"
+)
+
+type deferrerMock struct {
+	Key     string
+	At      time.Time
+	Options *WebPushOptions
+}
+
+func (d *deferrerMock) Schedule(ctx context.Context, key string, at time.Time, payload []byte, info *WebPushInfo, options *WebPushOptions) error {
+	d.Key, d.At, d.Options = key, at, options
+
+	return nil
+}
+
+func TestDeliveryPolicy(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lateEvening := time.Date(2024, 3, 16, 23, 30, 0, 0, berlin)
+
+	policyInfo := func(policy DeliveryPolicy) *WebPushInfo {
+		info := testInfo()
+		info.Policy = &policy
+
+		return info
+	}
+
+	quiet := DeliveryPolicy{
+		Timezone:   "Europe/Berlin",
+		QuietStart: TimeOfDay{Hour: 22},
+		QuietEnd:   TimeOfDay{Hour: 7},
+	}
+
+	t.Run("Defer until quiet hours end", func(t *testing.T) {
+		client := clientMock{}
+		deferrer := deferrerMock{}
+		webpush := NewWebPushClient(&client, &auth.SimpleJwtSigner{}, &ece.Aes128GcmEncoder{},
+			WithClock(clock.NewFake(lateEvening)), WithDeferrer(&deferrer))
+
+		res, err := webpush.SendWithResult(context.Background(), []byte("Hello"), policyInfo(quiet), &WebPushOptions{TTL: 86400, IdempotencyKey: "digest"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if client.Called {
+			t.Fatal("Message was sent during quiet hours")
+		}
+
+		until := time.Date(2024, 3, 17, 7, 0, 0, 0, berlin)
+		decision := res.Policy
+		if decision == nil || decision.Action != PolicyDefer || decision.Reason != ReasonQuietHours ||
+			!decision.Until.Equal(until) || decision.TTL != 86400-7*3600-1800 {
+			t.Fatal("Unexpected decision", res.Policy)
+		}
+
+		if !deferrer.At.Equal(until) || deferrer.Key == "" || deferrer.Options.TTL != 86400 {
+			t.Fatal("Message was not handed to the deferrer", deferrer)
+		}
+	})
+
+	t.Run("Drop when TTL ends before quiet hours", func(t *testing.T) {
+		client := clientMock{}
+		webpush := NewWebPushClient(&client, &auth.SimpleJwtSigner{}, &ece.Aes128GcmEncoder{}, WithClock(clock.NewFake(lateEvening)))
+
+		res, err := webpush.SendWithResult(context.Background(), []byte("Hello"), policyInfo(quiet), &WebPushOptions{TTL: 3600})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if client.Called || res.Policy.Action != PolicyDrop || res.Policy.Reason != ReasonExpired {
+			t.Fatal("Unexpected decision", res.Policy)
+		}
+	})
+
+	t.Run("Downgrade and drop actions", func(t *testing.T) {
+		client := clientMock{}
+		webpush := NewWebPushClient(&client, &auth.SimpleJwtSigner{}, &ece.Aes128GcmEncoder{}, WithClock(clock.NewFake(lateEvening)))
+
+		downgrade := quiet
+		downgrade.QuietAction = PolicyDowngrade
+
+		res, err := webpush.SendWithResult(context.Background(), []byte("Hello"), policyInfo(downgrade), &WebPushOptions{Urgency: "high", TTL: 60})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if res.Response == nil || res.Policy.Action != PolicyDowngrade {
+			t.Fatal("Unexpected decision", res.Policy)
+		}
+
+		if urgency := client.Request.Header.Get("Urgency"); urgency != "very-low" {
+			t.Fatal("Urgency was not downgraded", urgency)
+		}
+
+		drop := quiet
+		drop.QuietAction = PolicyDrop
+		client = clientMock{}
+
+		res, err = webpush.SendWithResult(context.Background(), []byte("Hello"), policyInfo(drop), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if client.Called || res.Response != nil || res.Policy.Action != PolicyDrop {
+			t.Fatal("Unexpected decision", res.Policy)
+		}
+	})
+
+	t.Run("Daily limit", func(t *testing.T) {
+		fake := clock.NewFake(time.Date(2024, 3, 16, 10, 0, 0, 0, berlin))
+		client := flakyClientMock{Statuses: []int{201, 500, 201, 201}}
+		webpush := NewWebPushClient(&client, &auth.SimpleJwtSigner{}, &ece.Aes128GcmEncoder{}, WithClock(fake))
+
+		limited := DeliveryPolicy{Timezone: "Europe/Berlin", MaxPerDay: 2}
+
+		var actions []PolicyAction
+		send := func() {
+			res, err := webpush.SendWithResult(context.Background(), []byte("Hello"), policyInfo(limited), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			actions = append(actions, res.Policy.Action)
+		}
+
+		send()
+		send()
+		send()
+		send()
+
+		fake.Advance(24 * time.Hour)
+		send()
+
+		expected := []PolicyAction{PolicyDeliver, PolicyDeliver, PolicyDeliver, PolicyDrop, PolicyDeliver}
+		for i := range expected {
+			if actions[i] != expected[i] {
+				t.Fatal("Unexpected decisions", actions)
+			}
+		}
+
+		if client.Calls != 4 {
+			t.Fatal("Unexpected number of requests", client.Calls)
+		}
+	})
+
+	t.Run("Prune past days", func(t *testing.T) {
+		counter := NewMemoryDeliveryCounter()
+		counter.Reserve("a", "2024-03-15", 1)
+		counter.Reserve("b", "2024-03-16", 1)
+		counter.Reserve("c", "2024-03-17", 1)
+
+		if len(counter.counts) != 2 {
+			t.Fatal("Past days were not pruned", counter.counts)
+		}
+	})
+
+	t.Run("Release without a result", func(t *testing.T) {
+		empty := func(next SendFunc) SendFunc {
+			return func(ctx context.Context, msg *Message) (*SendResult, error) {
+				return nil, nil
+			}
+		}
+
+		counter := NewMemoryDeliveryCounter()
+		webpush := NewWebPushClient(&clientMock{}, &auth.SimpleJwtSigner{}, &ece.Aes128GcmEncoder{},
+			WithDeliveryCounter(counter),
+			WithMiddleware(empty),
+			WithLayerOrder(LayerPolicy),
+		)
+
+		webpush.SendWithResult(context.Background(), []byte("Hello"), policyInfo(DeliveryPolicy{Timezone: "Europe/Berlin", MaxPerDay: 1}), nil)
+
+		if len(counter.counts) != 1 {
+			t.Fatal("Nothing was reserved", counter.counts)
+		}
+
+		for _, entry := range counter.counts {
+			if entry.count != 0 {
+				t.Fatal("Reservation was not released", counter.counts)
+			}
+		}
+	})
+
+	t.Run("Chunked message over the daily limit", func(t *testing.T) {
+		fake := clock.NewFake(time.Date(2024, 3, 16, 10, 0, 0, 0, berlin))
+		webpush := NewWebPushClient(&clientMock{}, &auth.SimpleJwtSigner{}, &ece.Aes128GcmEncoder{}, WithClock(fake))
+
+		results, err := webpush.SendChunked(context.Background(), make([]byte, 10000), policyInfo(DeliveryPolicy{Timezone: "Europe/Berlin", MaxPerDay: 2}), nil)
+		if !errors.Is(err, ErrMessageDropped) || len(results) != 3 {
+			t.Fatal("Partially delivered message was reported as sent", err, len(results))
+		}
+	})
+
+	t.Run("Policy without quiet hours", func(t *testing.T) {
+		client := clientMock{}
+		webpush := NewWebPushClient(&client, &auth.SimpleJwtSigner{}, &ece.Aes128GcmEncoder{}, WithClock(clock.NewFake(lateEvening)))
+
+		res, err := webpush.SendWithResult(context.Background(), []byte("Hello"), policyInfo(DeliveryPolicy{Timezone: "Europe/Berlin"}), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !client.Called || res.Response.StatusCode != http.StatusCreated || res.Policy.Action != PolicyDeliver {
+			t.Fatal("Unexpected decision", res.Policy)
+		}
+	})
+
+	t.Run("JSON encoding", func(t *testing.T) {
+		var policy DeliveryPolicy
+		if err := json.Unmarshal([]byte(`{"timezone": "Asia/Tokyo", "quietStart": "21:30", "quietEnd": "06:45", "quietAction": "downgrade", "maxPerDay": 3}`), &policy); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := policy.Validate(); err != nil {
+			t.Fatal(err)
+		}
+
+		if policy.QuietStart != (TimeOfDay{Hour: 21, Minute: 30}) || policy.QuietEnd != (TimeOfDay{Hour: 6, Minute: 45}) {
+			t.Fatal("Unexpected quiet hours", policy)
+		}
+
+		data, err := json.Marshal(policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(data) != `{"timezone":"Asia/Tokyo","quietStart":"21:30","quietEnd":"06:45","quietAction":"downgrade","maxPerDay":3}` {
+			t.Fatal("Unexpected encoding", string(data))
+		}
+
+		policy.Timezone = "Mars/Olympus"
+		if err := policy.Validate(); err == nil {
+			t.Fatal("Unknown timezone was accepted")
+		}
+	})
+}
@@ -0,0 +1,109 @@
+package webpush
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Firebain/webpush-go/internal/base64"
+)
+
+var (
+	ErrMessageExpired    = errors.New("push message expired")
+	ErrVapidTokenExpired = errors.New("vapid token expired and can't be renewed")
+)
+
+// tokenRenewMargin keeps a token that is about to expire from reaching the
+// push service.
+const tokenRenewMargin = time.Minute
+
+// PreparedMessage outlives its VAPID token: SendPrepared signs a new one once
+// TokenExpires is near. Per-call VapidDetails are kept in memory only, so a
+// decoded message needs a tenant registry, key ring or key provider for that.
+type PreparedMessage struct {
+	Endpoint     string      `json:"endpoint"`
+	Headers      http.Header `json:"headers"`
+	Body         []byte      `json:"body"`
+	Expires      time.Time   `json:"expires"`
+	TokenExpires time.Time   `json:"tokenExpires"`
+	KeyID        string      `json:"keyId,omitempty"`
+	TenantID     string      `json:"tenantId,omitempty"`
+
+	vapid VapidDetails
+}
+
+func (m *PreparedMessage) Request(ctx context.Context) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", m.Endpoint, bytes.NewReader(m.Body))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header = m.Headers.Clone()
+
+	return req, nil
+}
+
+func (m *PreparedMessage) Expired(now time.Time) bool {
+	return now.After(m.Expires)
+}
+
+func (m *PreparedMessage) TokenExpired(now time.Time) bool {
+	return !m.TokenExpires.IsZero() && !now.Add(tokenRenewMargin).Before(m.TokenExpires)
+}
+
+func (m *PreparedMessage) info() *WebPushInfo {
+	return &WebPushInfo{
+		Subscription: Subscription{
+			Endpoint: m.Endpoint,
+			KeyID:    m.KeyID,
+		},
+		VapidDetails: m.vapid,
+		TenantID:     m.TenantID,
+	}
+}
+
+func (m *PreparedMessage) options(now time.Time) *WebPushOptions {
+	return &WebPushOptions{
+		Urgency: m.Headers.Get("Urgency"),
+		Topic:   m.Headers.Get("Topic"),
+		TTL:     m.RemainingTTL(now),
+		Receipt: m.Headers.Get("Push-Receipt"),
+	}
+}
+
+func (m *PreparedMessage) RemainingTTL(now time.Time) int {
+	remaining := m.Expires.Sub(now)
+	if remaining <= 0 {
+		return 0
+	}
+
+	return int((remaining + time.Second - 1) / time.Second)
+}
+
+// vapidExpiry reads the exp claim of the JWT in a VAPID Authorization header.
+func vapidExpiry(header string) (time.Time, bool) {
+	token, _, _ := strings.Cut(strings.TrimPrefix(header, "vapid t="), ",")
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}, false
+	}
+
+	data, err := base64.DecodeUrlBase64(parts[1])
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if err := json.Unmarshal(data, &claims); err != nil || claims.Exp == 0 {
+		return time.Time{}, false
+	}
+
+	return time.Unix(claims.Exp, 0), true
+}
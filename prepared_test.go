@@ -0,0 +1,135 @@
+package webpush
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Firebain/webpush-go/auth"
+	"github.com/Firebain/webpush-go/clock"
+	"github.com/Firebain/webpush-go/ece"
+)
+
+func testInfo() *WebPushInfo {
+	return &WebPushInfo{
+		Subscription: Subscription{
+			Endpoint: "https://test-ns.com/ns/token",
+			Keys: SubscriptionKeys{
+				P256DH: "BFGGjgyqdoqg10kasOdjQ9M_XCGCUrHe9XdOtFtGgRQmxseX0rDCPnmkqUXK0sEhF30to0G4TonsvnxWq6BJrIA",
+				Auth:   "PVi3VfghXXXOELqDxy0oDA",
+			},
+		},
+		VapidDetails: VapidDetails{
+			Subject: "example@push.com",
+			VapidKeys: VapidKeys{
+				PrivateKey: "BdqJiVn-wHy0Jsr8kJ9kAceyuihPf31RiBP7SWtG5eU",
+				PublicKey:  "BC6EjsLzlGi7OaUSrB0MuURkbcdgq8XsTR3EwqwDhclzmh9xPCtpp50UCYgUV3IKwy3onLBhrtlWJktGzFapjGc",
+			},
+		},
+	}
+}
+
+func TestPreparedMessage(t *testing.T) {
+	fake := clock.NewFake(time.Unix(1710588595, 0))
+	client := clientMock{}
+	sends := 0
+	counting := func(next SendFunc) SendFunc {
+		return func(ctx context.Context, msg *Message) (*SendResult, error) {
+			sends++
+			return next(ctx, msg)
+		}
+	}
+
+	webpush := NewWebPushClient(&client, &auth.SimpleJwtSigner{Clock: fake}, &ece.Aes128GcmEncoder{}, WithClock(fake), WithMiddleware(counting))
+
+	msg, err := webpush.Prepare([]byte("Hello World!"), testInfo(), &WebPushOptions{
+		Urgency: "high",
+		Topic:   "news",
+		TTL:     60,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encoded, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded PreparedMessage
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Send with remaining TTL", func(t *testing.T) {
+		fake.Advance(20 * time.Second)
+
+		res, err := webpush.SendPrepared(context.Background(), &decoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+
+		header := client.Request.Header
+		if header.Get("TTL") != "40" || header.Get("Urgency") != "high" || header.Get("Topic") != "news" {
+			t.Fatal("Unexpected headers", header)
+		}
+
+		if sends != 1 {
+			t.Fatal("Prepared message skipped the middleware")
+		}
+	})
+
+	t.Run("Reject expired message", func(t *testing.T) {
+		fake.Advance(time.Minute)
+
+		_, err := webpush.SendPrepared(context.Background(), &decoded)
+		if !errors.Is(err, ErrMessageExpired) {
+			t.Fatal("Expired message was sent", err)
+		}
+	})
+
+	t.Run("Renew the VAPID token", func(t *testing.T) {
+		long, err := webpush.Prepare([]byte("Hello World!"), testInfo(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !long.Expires.Equal(fake.Now().Add(DefaultTTL*time.Second)) || !long.TokenExpires.Equal(fake.Now().Add(12*time.Hour)) {
+			t.Fatal("Unexpected expiry", long.Expires, long.TokenExpires)
+		}
+
+		fake.Advance(13 * time.Hour)
+
+		res, err := webpush.SendPrepared(context.Background(), long)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+
+		header := client.Request.Header.Get("Authorization")
+		if header == long.Headers.Get("Authorization") {
+			t.Fatal("Expired VAPID token was sent")
+		}
+
+		if exp, ok := vapidExpiry(header); !ok || !exp.Equal(fake.Now().Add(12*time.Hour)) {
+			t.Fatal("Unexpected token expiry", exp)
+		}
+
+		encoded, err := json.Marshal(long)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var decoded PreparedMessage
+		if err := json.Unmarshal(encoded, &decoded); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := webpush.SendPrepared(context.Background(), &decoded); !errors.Is(err, ErrVapidTokenExpired) {
+			t.Fatal("Token was renewed without a key source", err)
+		}
+	})
+}
@@ -0,0 +1,282 @@
+package webpush
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Firebain/webpush-go/clock"
+)
+
+const (
+	DefaultPublicKeyEnv  = "VAPID_PUBLIC_KEY"
+	DefaultPrivateKeyEnv = "VAPID_PRIVATE_KEY"
+	DefaultSubjectEnv    = "VAPID_SUBJECT"
+)
+
+// KeyProvider is consulted at send time when WebPushInfo has no private key.
+type KeyProvider interface {
+	VapidDetails(ctx context.Context, sub *Subscription) (VapidDetails, error)
+}
+
+type KeyProviderFunc func(ctx context.Context, sub *Subscription) (VapidDetails, error)
+
+func (f KeyProviderFunc) VapidDetails(ctx context.Context, sub *Subscription) (VapidDetails, error) {
+	return f(ctx, sub)
+}
+
+func (r *KeyRing) VapidDetails(ctx context.Context, sub *Subscription) (VapidDetails, error) {
+	return r.Lookup(sub.KeyID)
+}
+
+// EnvKeyProvider accepts the private key as url-safe base64 or a PEM block.
+type EnvKeyProvider struct {
+	PublicKeyVar  string
+	PrivateKeyVar string
+	SubjectVar    string
+}
+
+func (p *EnvKeyProvider) VapidDetails(ctx context.Context, sub *Subscription) (VapidDetails, error) {
+	private := os.Getenv(envName(p.PrivateKeyVar, DefaultPrivateKeyEnv))
+	if private == "" {
+		return VapidDetails{}, errors.New("vapid private key is not set")
+	}
+
+	details := VapidDetails{
+		Subject: os.Getenv(envName(p.SubjectVar, DefaultSubjectEnv)),
+	}
+
+	if strings.HasPrefix(strings.TrimSpace(private), "-----BEGIN") {
+		keys, err := ParseVapidKeysPEM([]byte(private))
+		if err != nil {
+			return VapidDetails{}, err
+		}
+
+		details.VapidKeys = *keys
+
+		return details, nil
+	}
+
+	details.PrivateKey = private
+	details.PublicKey = os.Getenv(envName(p.PublicKeyVar, DefaultPublicKeyEnv))
+
+	return details, nil
+}
+
+func envName(name string, fallback string) string {
+	if name == "" {
+		return fallback
+	}
+
+	return name
+}
+
+// FileKeyProvider keeps serving the last loaded keys when a reload fails.
+type FileKeyProvider struct {
+	path     string
+	subject  string
+	interval time.Duration
+	clock    clock.Clock
+
+	mu      sync.RWMutex
+	details VapidDetails
+	modTime time.Time
+	size    int64
+	checked time.Time
+}
+
+const defaultFileRecheckInterval = time.Second
+
+type FileKeyProviderOption func(*FileKeyProvider)
+
+// WithFileRecheckInterval sets how long the file is trusted before it is
+// stat'ed again. Zero checks on every call.
+func WithFileRecheckInterval(interval time.Duration) FileKeyProviderOption {
+	return func(p *FileKeyProvider) {
+		p.interval = interval
+	}
+}
+
+func WithFileClock(clk clock.Clock) FileKeyProviderOption {
+	return func(p *FileKeyProvider) {
+		p.clock = clk
+	}
+}
+
+func NewFileKeyProvider(path string, subject string, opts ...FileKeyProviderOption) (*FileKeyProvider, error) {
+	p := &FileKeyProvider{
+		path:     path,
+		subject:  subject,
+		interval: defaultFileRecheckInterval,
+	}
+
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	p.clock = clock.OrSystem(p.clock)
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.load(info); err != nil {
+		return nil, err
+	}
+
+	p.checked = p.clock.Now()
+
+	return p, nil
+}
+
+func (p *FileKeyProvider) VapidDetails(ctx context.Context, sub *Subscription) (VapidDetails, error) {
+	if p.due() {
+		if info, err := os.Stat(p.path); err == nil && p.changed(info) {
+			_ = p.load(info)
+		}
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.details, nil
+}
+
+func (p *FileKeyProvider) due() bool {
+	now := p.clock.Now()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if now.Sub(p.checked) < p.interval {
+		return false
+	}
+
+	p.checked = now
+
+	return true
+}
+
+func (p *FileKeyProvider) changed(info os.FileInfo) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return !info.ModTime().Equal(p.modTime) || info.Size() != p.size
+}
+
+func (p *FileKeyProvider) load(info os.FileInfo) error {
+	data, err := os.ReadFile(p.path)
+	if err != nil {
+		return err
+	}
+
+	details, err := parseKeyFile(data)
+	if err != nil {
+		return err
+	}
+
+	if details.Subject == "" {
+		details.Subject = p.subject
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.details = details
+	p.modTime = info.ModTime()
+	p.size = info.Size()
+
+	return nil
+}
+
+func parseKeyFile(data []byte) (VapidDetails, error) {
+	trimmed := bytes.TrimSpace(data)
+	if !bytes.HasPrefix(trimmed, []byte("{")) {
+		keys, err := ParseVapidKeysPEM(trimmed)
+		if err != nil {
+			return VapidDetails{}, err
+		}
+
+		return VapidDetails{VapidKeys: *keys}, nil
+	}
+
+	var probe struct {
+		Kty string `json:"kty"`
+	}
+	if err := json.Unmarshal(trimmed, &probe); err != nil {
+		return VapidDetails{}, err
+	}
+
+	if probe.Kty != "" {
+		keys, err := ParseVapidKeysJWK(trimmed)
+		if err != nil {
+			return VapidDetails{}, err
+		}
+
+		return VapidDetails{VapidKeys: *keys}, nil
+	}
+
+	var details VapidDetails
+	if err := json.Unmarshal(trimmed, &details); err != nil {
+		return VapidDetails{}, err
+	}
+
+	if err := details.Validate(); err != nil {
+		return VapidDetails{}, err
+	}
+
+	return details, nil
+}
+
+type cachedKeys struct {
+	details VapidDetails
+	expires time.Time
+}
+
+type cachingKeyProvider struct {
+	provider KeyProvider
+	ttl      time.Duration
+	clock    clock.Clock
+
+	mu      sync.Mutex
+	entries map[string]cachedKeys
+}
+
+// CacheKeyProvider caches keys per key id for ttl. Errors are not cached.
+func CacheKeyProvider(provider KeyProvider, ttl time.Duration, clk clock.Clock) KeyProvider {
+	return &cachingKeyProvider{
+		provider: provider,
+		ttl:      ttl,
+		clock:    clock.OrSystem(clk),
+		entries:  make(map[string]cachedKeys),
+	}
+}
+
+func (p *cachingKeyProvider) VapidDetails(ctx context.Context, sub *Subscription) (VapidDetails, error) {
+	now := p.clock.Now()
+
+	p.mu.Lock()
+	entry, ok := p.entries[sub.KeyID]
+	p.mu.Unlock()
+
+	if ok && now.Before(entry.expires) {
+		return entry.details, nil
+	}
+
+	details, err := p.provider.VapidDetails(ctx, sub)
+	if err != nil {
+		return VapidDetails{}, err
+	}
+
+	p.mu.Lock()
+	p.entries[sub.KeyID] = cachedKeys{details: details, expires: now.Add(p.ttl)}
+	p.mu.Unlock()
+
+	return details, nil
+}
@@ -0,0 +1,238 @@
+package webpush
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Firebain/webpush-go/auth"
+	"github.com/Firebain/webpush-go/clock"
+	"github.com/Firebain/webpush-go/ece"
+)
+
+func signedWith(t *testing.T, client *clientMock, keys *VapidKeys) bool {
+	t.Helper()
+
+	if client.Request == nil {
+		t.Fatal("No request was sent")
+	}
+
+	return strings.Contains(client.Request.Header.Get("Authorization"), "k="+keys.PublicKey)
+}
+
+func TestKeyProvider(t *testing.T) {
+	first, err := GenerateVapidKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := GenerateVapidKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info := testInfo()
+	info.VapidDetails = VapidDetails{}
+
+	t.Run("Callback provider resolves at send time", func(t *testing.T) {
+		current := first
+		provider := KeyProviderFunc(func(ctx context.Context, sub *Subscription) (VapidDetails, error) {
+			return VapidDetails{Subject: "example@push.com", VapidKeys: *current}, nil
+		})
+
+		client := clientMock{}
+		webpush := NewWebPushClient(&client, &auth.SimpleJwtSigner{}, &ece.Aes128GcmEncoder{}, WithKeyProvider(provider))
+
+		if _, err := webpush.Send([]byte("Hello"), info, nil); err != nil {
+			t.Fatal(err)
+		}
+
+		if !signedWith(t, &client, first) {
+			t.Fatal("Message was not signed with the first key")
+		}
+
+		current = second
+		if _, err := webpush.Send([]byte("Hello"), info, nil); err != nil {
+			t.Fatal(err)
+		}
+
+		if !signedWith(t, &client, second) {
+			t.Fatal("Message was not signed with the rotated key")
+		}
+	})
+
+	t.Run("Reject combination with a key ring", func(t *testing.T) {
+		provider := KeyProviderFunc(func(ctx context.Context, sub *Subscription) (VapidDetails, error) {
+			return VapidDetails{Subject: "example@push.com", VapidKeys: *first}, nil
+		})
+
+		ring := NewKeyRing()
+		if err := ring.Add("v1", VapidDetails{Subject: "example@push.com", VapidKeys: *second}); err != nil {
+			t.Fatal(err)
+		}
+
+		defer func() {
+			if err, ok := recover().(error); !ok || !errors.Is(err, ErrConflictingKeySources) {
+				t.Fatal("Key ring and key provider were combined")
+			}
+		}()
+
+		NewWebPushClient(&clientMock{}, &auth.SimpleJwtSigner{}, &ece.Aes128GcmEncoder{}, WithKeyRing(ring), WithKeyProvider(provider))
+	})
+
+	t.Run("Explicit details take precedence", func(t *testing.T) {
+		provider := KeyProviderFunc(func(ctx context.Context, sub *Subscription) (VapidDetails, error) {
+			t.Fatal("Provider should not be called")
+			return VapidDetails{}, nil
+		})
+
+		client := clientMock{}
+		webpush := NewWebPushClient(&client, &auth.SimpleJwtSigner{}, &ece.Aes128GcmEncoder{}, WithKeyProvider(provider))
+
+		if _, err := webpush.Send([]byte("Hello"), testInfo(), nil); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("Environment provider", func(t *testing.T) {
+		t.Setenv(DefaultPublicKeyEnv, first.PublicKey)
+		t.Setenv(DefaultPrivateKeyEnv, first.PrivateKey)
+		t.Setenv(DefaultSubjectEnv, "mailto:env@push.com")
+
+		provider := &EnvKeyProvider{}
+		details, err := provider.VapidDetails(context.Background(), &info.Subscription)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if details.VapidKeys != *first || details.Subject != "mailto:env@push.com" {
+			t.Fatal("Unexpected details", details)
+		}
+
+		pem, err := second.EncodePEM(FormatPKCS8)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		t.Setenv("PUSH_KEY", string(pem))
+		provider = &EnvKeyProvider{PrivateKeyVar: "PUSH_KEY"}
+		details, err = provider.VapidDetails(context.Background(), &info.Subscription)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if details.VapidKeys != *second {
+			t.Fatal("PEM key was not parsed")
+		}
+
+		t.Setenv("PUSH_KEY", "")
+		if _, err := provider.VapidDetails(context.Background(), &info.Subscription); err == nil {
+			t.Fatal("Missing key was accepted")
+		}
+	})
+
+	t.Run("File provider reloads on change", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "vapid.pem")
+
+		pem, err := first.EncodePEM(FormatSEC1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(path, pem, 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		fake := clock.NewFake(time.Unix(1710588595, 0))
+		provider, err := NewFileKeyProvider(path, "mailto:file@push.com", WithFileClock(fake))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		client := clientMock{}
+		webpush := NewWebPushClient(&client, &auth.SimpleJwtSigner{}, &ece.Aes128GcmEncoder{}, WithKeyProvider(provider))
+
+		if _, err := webpush.Send([]byte("Hello"), info, nil); err != nil {
+			t.Fatal(err)
+		}
+
+		if !signedWith(t, &client, first) {
+			t.Fatal("Message was not signed with the file key")
+		}
+
+		data := `{"subject": "mailto:json@push.com", "publicKey": "` + second.PublicKey + `", "privateKey": "` + second.PrivateKey + `"}`
+		if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		later := time.Now().Add(time.Minute)
+		if err := os.Chtimes(path, later, later); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := webpush.Send([]byte("Hello"), info, nil); err != nil {
+			t.Fatal(err)
+		}
+
+		if !signedWith(t, &client, first) {
+			t.Fatal("File was checked before the recheck interval")
+		}
+
+		fake.Advance(time.Second)
+
+		if _, err := webpush.Send([]byte("Hello"), info, nil); err != nil {
+			t.Fatal(err)
+		}
+
+		if !signedWith(t, &client, second) {
+			t.Fatal("Message was not signed with the reloaded key")
+		}
+
+		if err := os.WriteFile(path, []byte("garbage"), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		fake.Advance(time.Second)
+
+		details, err := provider.VapidDetails(context.Background(), &info.Subscription)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if details.VapidKeys != *second || details.Subject != "mailto:json@push.com" {
+			t.Fatal("Broken file replaced the loaded keys", details)
+		}
+	})
+
+	t.Run("Cached provider", func(t *testing.T) {
+		fake := clock.NewFake(time.Unix(1710588595, 0))
+		calls := 0
+		provider := CacheKeyProvider(KeyProviderFunc(func(ctx context.Context, sub *Subscription) (VapidDetails, error) {
+			calls++
+			return VapidDetails{Subject: "example@push.com", VapidKeys: *first}, nil
+		}), time.Minute, fake)
+
+		for i := 0; i < 3; i++ {
+			if _, err := provider.VapidDetails(context.Background(), &info.Subscription); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if calls != 1 {
+			t.Fatal("Expected a single call, got", calls)
+		}
+
+		fake.Advance(time.Minute)
+		if _, err := provider.VapidDetails(context.Background(), &info.Subscription); err != nil {
+			t.Fatal(err)
+		}
+
+		if calls != 2 {
+			t.Fatal("Expected a refresh after expiry, got", calls)
+		}
+	})
+}
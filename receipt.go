@@ -0,0 +1,214 @@
+package webpush
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Firebain/webpush-go/clock"
+)
+
+const receiptLinkRel = "urn:ietf:params:push"
+
+// DefaultReceiptWindow is how long a message stays tracked at least, so that
+// receipts for messages with a short or zero TTL still arrive in time.
+const DefaultReceiptWindow = time.Minute
+
+type Receipt struct {
+	Location string
+	Handle   *MessageHandle
+	Received time.Time
+}
+
+// ReceiptTracker forgets messages once their TTL, or MinWindow if that is
+// longer, has passed. Receipts are not authenticated by the push service, so
+// without Verify ServeHTTP accepts any POST naming a tracked message.
+type ReceiptTracker struct {
+	OnReceipt func(Receipt)
+	Verify    func(r *http.Request) bool
+	MinWindow time.Duration
+
+	clock    clock.Clock
+	mu       sync.Mutex
+	handles  map[string]trackedHandle
+	swept    time.Time
+	receipts chan Receipt
+}
+
+type trackedHandle struct {
+	handle  *MessageHandle
+	expires time.Time
+}
+
+const receiptSweepInterval = time.Minute
+
+func NewReceiptTracker(buffer int, clk clock.Clock) *ReceiptTracker {
+	return &ReceiptTracker{
+		MinWindow: DefaultReceiptWindow,
+		clock:     clock.OrSystem(clk),
+		handles:   make(map[string]trackedHandle),
+		receipts:  make(chan Receipt, buffer),
+	}
+}
+
+func (t *ReceiptTracker) Track(handle *MessageHandle) {
+	now := t.clock.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if now.Sub(t.swept) >= receiptSweepInterval {
+		t.sweep(now)
+	}
+
+	t.handles[handle.Location] = trackedHandle{
+		handle:  handle,
+		expires: now.Add(max(time.Duration(handle.Options.TTL)*time.Second, t.MinWindow)),
+	}
+}
+
+func (t *ReceiptTracker) sweep(now time.Time) {
+	for location, tracked := range t.handles {
+		if now.After(tracked.expires) {
+			delete(t.handles, location)
+		}
+	}
+
+	t.swept = now
+}
+
+func (t *ReceiptTracker) Forget(location string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.handles, location)
+}
+
+func (t *ReceiptTracker) Pending() int {
+	now := t.clock.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.sweep(now)
+
+	return len(t.handles)
+}
+
+func (t *ReceiptTracker) Receipts() <-chan Receipt {
+	return t.receipts
+}
+
+func (t *ReceiptTracker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	if t.Verify != nil && !t.Verify(r) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	location := receiptLocation(r)
+	if location == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	now := t.clock.Now()
+
+	t.mu.Lock()
+	tracked, ok := t.handles[location]
+	delete(t.handles, location)
+	t.mu.Unlock()
+
+	if !ok || now.After(tracked.expires) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	receipt := Receipt{
+		Location: location,
+		Handle:   tracked.handle,
+		Received: now,
+	}
+
+	if t.OnReceipt != nil {
+		t.OnReceipt(receipt)
+	}
+
+	select {
+	case t.receipts <- receipt:
+	default:
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func receiptLocation(r *http.Request) string {
+	for _, link := range r.Header.Values("Link") {
+		for _, value := range strings.Split(link, ",") {
+			target, params, ok := strings.Cut(strings.TrimSpace(value), ";")
+			if !ok || !strings.Contains(params, `rel="`+receiptLinkRel+`"`) {
+				continue
+			}
+
+			target = strings.Trim(strings.TrimSpace(target), "<>")
+
+			location, err := r.URL.Parse(target)
+			if err != nil {
+				return ""
+			}
+
+			return location.String()
+		}
+	}
+
+	return r.Header.Get("Content-Location")
+}
+
+func (c *WebPushClient) SubscribeReceipts(ctx context.Context, subscribeURL string, info *WebPushInfo) (string, error) {
+	endpoint, err := url.Parse(subscribeURL)
+	if err != nil {
+		return "", err
+	}
+
+	identity, err := c.vapidIdentity(ctx, info)
+	if err != nil {
+		return "", err
+	}
+
+	vapidHeader, _, err := identity.header(endpoint)
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", subscribeURL, nil)
+	if err != nil {
+		return "", err
+	}
+
+	req.Header.Add("Authorization", vapidHeader)
+
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusCreated {
+		return "", errors.New("receipt subscription failed: " + res.Status)
+	}
+
+	location, err := res.Location()
+	if err != nil {
+		return "", err
+	}
+
+	return location.String(), nil
+}
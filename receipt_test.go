@@ -0,0 +1,101 @@
+package webpush
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Firebain/webpush-go/auth"
+	"github.com/Firebain/webpush-go/clock"
+	"github.com/Firebain/webpush-go/ece"
+)
+
+func TestReceipts(t *testing.T) {
+	service := pushServiceMock{}
+	tracker := NewReceiptTracker(1, nil)
+	webpush := NewWebPushClient(&service, &auth.SimpleJwtSigner{}, &ece.Aes128GcmEncoder{}, WithReceiptTracker(tracker))
+
+	res, err := webpush.SendWithResult(context.Background(), []byte("Hello World!"), testInfo(), &WebPushOptions{
+		TTL:     60,
+		Receipt: "https://test-ns.com/r/receipts-1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Response.Body.Close()
+
+	header := service.Requests[0].Header
+	if header.Get("Push-Receipt") != "https://test-ns.com/r/receipts-1" || header.Get("Prefer") != "respond-async" {
+		t.Fatal("Unexpected headers", header)
+	}
+
+	if tracker.Pending() != 1 {
+		t.Fatal("Message is not tracked")
+	}
+
+	deliver := func() int {
+		req := httptest.NewRequest("POST", "https://app.example.com/receipts", nil)
+		req.Header.Set("Link", `<https://test-ns.com/m/message-1>; rel="urn:ietf:params:push"`)
+
+		rec := httptest.NewRecorder()
+		tracker.ServeHTTP(rec, req)
+
+		return rec.Code
+	}
+
+	if code := deliver(); code != http.StatusNoContent {
+		t.Fatal("Unexpected status", code)
+	}
+
+	receipt := <-tracker.Receipts()
+	if receipt.Handle != res.Handle {
+		t.Fatal("Receipt doesn't match sent message")
+	}
+
+	if code := deliver(); code != http.StatusNotFound {
+		t.Fatal("Unexpected status for repeated receipt", code)
+	}
+
+	t.Run("Expire after TTL", func(t *testing.T) {
+		fake := clock.NewFake(time.Unix(1710588595, 0))
+		tracker := NewReceiptTracker(1, fake)
+		tracker.Track(&MessageHandle{Location: "https://test-ns.com/m/message-2", Options: WebPushOptions{TTL: 60}})
+
+		fake.Advance(61 * time.Second)
+		if tracker.Pending() != 0 {
+			t.Fatal("Expired message is still tracked")
+		}
+	})
+
+	t.Run("Minimum window", func(t *testing.T) {
+		fake := clock.NewFake(time.Unix(1710588595, 0))
+		tracker := NewReceiptTracker(1, fake)
+		tracker.Track(&MessageHandle{Location: "https://test-ns.com/m/message-3", Options: WebPushOptions{TTL: 0}})
+
+		fake.Advance(DefaultReceiptWindow / 2)
+		if tracker.Pending() != 1 {
+			t.Fatal("Message with zero TTL expired before the receipt window")
+		}
+
+		tracker.MinWindow = 0
+		tracker.Track(&MessageHandle{Location: "https://test-ns.com/m/message-4", Options: WebPushOptions{TTL: 0}})
+
+		fake.Advance(time.Second)
+		if tracker.Pending() != 1 {
+			t.Fatal("Message outlived a zero receipt window")
+		}
+	})
+
+	t.Run("Verify requests", func(t *testing.T) {
+		tracker.Track(res.Handle)
+		tracker.Verify = func(r *http.Request) bool {
+			return r.URL.Query().Get("token") == "secret"
+		}
+
+		if code := deliver(); code != http.StatusUnauthorized {
+			t.Fatal("Unverified receipt was accepted", code)
+		}
+	})
+}
@@ -0,0 +1,283 @@
+package schedule
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	webpush "github.com/Firebain/webpush-go"
+	"github.com/Firebain/webpush-go/clock"
+)
+
+const DefaultWorkers = 16
+
+type Sender interface {
+	SendWithResult(ctx context.Context, payload []byte, info *webpush.WebPushInfo, options *webpush.WebPushOptions) (*webpush.SendResult, error)
+}
+
+type Options struct {
+	Workers       int
+	Clock         clock.Clock
+	OnResult      func(job *Job, res *webpush.SendResult, err error)
+	Subscriptions webpush.SubscriptionStore
+}
+
+type Scheduler struct {
+	sender        Sender
+	store         Store
+	workers       int
+	clock         clock.Clock
+	onResult      func(job *Job, res *webpush.SendResult, err error)
+	subscriptions webpush.SubscriptionStore
+	notify        chan struct{}
+
+	mu    sync.Mutex
+	infos map[string]webpush.WebPushInfo
+}
+
+func New(sender Sender, store Store, options *Options) *Scheduler {
+	s := &Scheduler{
+		sender:  sender,
+		store:   store,
+		workers: DefaultWorkers,
+		clock:   clock.System{},
+		notify:  make(chan struct{}, 1),
+		infos:   make(map[string]webpush.WebPushInfo),
+	}
+
+	if s.store == nil {
+		s.store = NewMemoryStore()
+	}
+
+	if options != nil {
+		if options.Workers > 0 {
+			s.workers = options.Workers
+		}
+
+		if options.Clock != nil {
+			s.clock = options.Clock
+		}
+
+		s.onResult = options.OnResult
+		s.subscriptions = options.Subscriptions
+	}
+
+	return s
+}
+
+func (s *Scheduler) Schedule(ctx context.Context, key string, at time.Time, payload []byte, info *webpush.WebPushInfo, options *webpush.WebPushOptions) error {
+	if key == "" {
+		return errors.New("schedule key is empty")
+	}
+
+	job := &Job{
+		Key:      key,
+		At:       at,
+		Created:  s.clock.Now(),
+		Payload:  payload,
+		Endpoint: info.Subscription.Endpoint,
+		Keys:     info.Subscription.Keys,
+		KeyID:    info.Subscription.KeyID,
+		TenantID: info.TenantID,
+		Policy:   info.Policy,
+	}
+
+	if options != nil {
+		opts := *options
+		job.Options = &opts
+	}
+
+	s.mu.Lock()
+	s.infos[key] = *info
+	s.mu.Unlock()
+
+	if err := s.store.Put(ctx, job); err != nil {
+		s.forget(key)
+		return err
+	}
+
+	s.wake()
+
+	return nil
+}
+
+func (s *Scheduler) ScheduleIn(ctx context.Context, key string, delay time.Duration, payload []byte, info *webpush.WebPushInfo, options *webpush.WebPushOptions) error {
+	return s.Schedule(ctx, key, s.clock.Now().Add(delay), payload, info, options)
+}
+
+func (s *Scheduler) Cancel(ctx context.Context, key string) (bool, error) {
+	ok, err := s.store.Delete(ctx, key)
+	if err != nil {
+		return false, err
+	}
+
+	s.forget(key)
+
+	s.wake()
+
+	return ok, nil
+}
+
+func (s *Scheduler) Run(ctx context.Context) error {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	slots := make(chan struct{}, s.workers)
+
+	for {
+		now := s.clock.Now()
+
+		jobs, err := s.store.Claim(ctx, now)
+		if err != nil {
+			return err
+		}
+
+		for i, job := range jobs {
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				s.requeue(jobs[i:])
+				return ctx.Err()
+			}
+
+			wg.Add(1)
+			go func(job *Job) {
+				defer wg.Done()
+				defer func() { <-slots }()
+				s.send(ctx, job, now)
+			}(job)
+		}
+
+		next, ok, err := s.store.Next(ctx)
+		if err != nil {
+			return err
+		}
+
+		var timer <-chan time.Time
+		if ok {
+			timer = s.clock.After(next.Sub(now))
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-s.notify:
+		case <-timer:
+		}
+	}
+}
+
+func (s *Scheduler) send(ctx context.Context, job *Job, now time.Time) {
+	options := webpush.WebPushOptions{TTL: webpush.DefaultTTL}
+	if job.Options != nil {
+		options = *job.Options
+	}
+
+	created := job.Created
+	if created.IsZero() {
+		created = job.At
+	}
+
+	waited := int(now.Sub(created) / time.Second)
+	if options.TTL > 0 && waited > 0 {
+		if waited >= options.TTL {
+			s.report(job, nil, webpush.ErrMessageExpired)
+			return
+		}
+
+		options.TTL -= waited
+	}
+
+	info, err := s.resolve(ctx, job)
+	if err != nil {
+		s.report(job, nil, err)
+		return
+	}
+
+	res, err := s.sender.SendWithResult(ctx, job.Payload, info, &options)
+	s.report(job, res, err)
+}
+
+func (s *Scheduler) resolve(ctx context.Context, job *Job) (*webpush.WebPushInfo, error) {
+	if info, ok := s.forget(job.Key); ok {
+		return &info, nil
+	}
+
+	info := &webpush.WebPushInfo{
+		Subscription: webpush.Subscription{
+			Endpoint: job.Endpoint,
+			Keys:     job.Keys,
+			KeyID:    job.KeyID,
+		},
+		TenantID: job.TenantID,
+		Policy:   job.Policy,
+	}
+
+	if job.Keys != (webpush.SubscriptionKeys{}) {
+		return info, nil
+	}
+
+	// Jobs stored before their keys were persisted.
+	if s.subscriptions == nil {
+		return nil, webpush.ErrSubscriptionNotFound
+	}
+
+	rec, err := s.subscriptions.Get(ctx, info.Subscription.ID())
+	if err != nil {
+		return nil, err
+	}
+
+	info.Subscription.Keys = rec.Subscription.Keys
+	if info.Subscription.KeyID == "" {
+		info.Subscription.KeyID = rec.Subscription.KeyID
+	}
+
+	return info, nil
+}
+
+// requeue puts claimed jobs back when Run stops before sending them.
+func (s *Scheduler) requeue(jobs []*Job) {
+	for _, job := range jobs {
+		s.store.Put(context.Background(), job)
+	}
+}
+
+func (s *Scheduler) forget(key string) (webpush.WebPushInfo, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info, ok := s.infos[key]
+	delete(s.infos, key)
+
+	return info, ok
+}
+
+func (s *Scheduler) report(job *Job, res *webpush.SendResult, err error) {
+	if s.onResult != nil {
+		s.onResult(job, res, err)
+		return
+	}
+
+	if res != nil && res.Response != nil {
+		res.Response.Body.Close()
+	}
+}
+
+func (s *Scheduler) wake() {
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+func NextLocalTime(now time.Time, loc *time.Location, hour int, minute int) time.Time {
+	local := now.In(loc)
+
+	at := time.Date(local.Year(), local.Month(), local.Day(), hour, minute, 0, 0, loc)
+	if !at.After(local) {
+		at = time.Date(local.Year(), local.Month(), local.Day()+1, hour, minute, 0, 0, loc)
+	}
+
+	return at
+}
@@ -0,0 +1,352 @@
+package schedule
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	webpush "github.com/Firebain/webpush-go"
+	"github.com/Firebain/webpush-go/auth"
+	"github.com/Firebain/webpush-go/clock"
+	"github.com/Firebain/webpush-go/ece"
+)
+
+type senderMock struct {
+	mu    sync.Mutex
+	sent  []webpush.WebPushOptions
+	infos []webpush.WebPushInfo
+}
+
+func (s *senderMock) SendWithResult(ctx context.Context, payload []byte, info *webpush.WebPushInfo, options *webpush.WebPushOptions) (*webpush.SendResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sent = append(s.sent, *options)
+	s.infos = append(s.infos, *info)
+
+	return &webpush.SendResult{
+		Response: &http.Response{
+			StatusCode: 201,
+			Body:       io.NopCloser(bytes.NewReader([]byte{})),
+		},
+	}, nil
+}
+
+func (s *senderMock) Sent() []webpush.WebPushOptions {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]webpush.WebPushOptions(nil), s.sent...)
+}
+
+func TestScheduler(t *testing.T) {
+	fake := clock.NewFake(time.Unix(1710588595, 0))
+	sender := &senderMock{}
+	scheduler := New(sender, nil, &Options{Clock: fake})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		scheduler.Run(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	info := &webpush.WebPushInfo{}
+	options := &webpush.WebPushOptions{TTL: 3600}
+
+	if err := scheduler.ScheduleIn(ctx, "reminder", 30*time.Minute, []byte("reminder"), info, options); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := scheduler.ScheduleIn(ctx, "cancelled", 10*time.Minute, []byte("cancelled"), info, options); err != nil {
+		t.Fatal(err)
+	}
+
+	ok, err := scheduler.Cancel(ctx, "cancelled")
+	if err != nil || !ok {
+		t.Fatal("Job was not cancelled", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(sender.Sent()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Job was not sent")
+		}
+
+		fake.Advance(time.Minute)
+		time.Sleep(time.Millisecond)
+	}
+
+	sent := sender.Sent()
+	if len(sent) != 1 || sent[0].TTL != 1800 {
+		t.Fatalf("Unexpected sends %+v", sent)
+	}
+}
+
+func TestSchedulerResolvesStoredJobs(t *testing.T) {
+	fake := clock.NewFake(time.Unix(1710588595, 0))
+	sender := &senderMock{}
+	store := NewMemoryStore()
+	subscriptions := webpush.NewMemorySubscriptionStore()
+
+	sub := webpush.Subscription{
+		Endpoint: "https://test-ns.com/ns/token",
+		Keys:     webpush.SubscriptionKeys{P256DH: "p256dh", Auth: "auth"},
+	}
+
+	ctx := context.Background()
+	if err := subscriptions.Put(ctx, webpush.NewSubscriptionRecord(sub)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Jobs left in the store by an earlier process have no VAPID keys in
+	// memory. Older jobs also lack the subscription keys.
+	persisted := webpush.SubscriptionKeys{P256DH: "persisted", Auth: "auth"}
+	jobs := []*Job{
+		{Key: "legacy", At: fake.Now(), Endpoint: sub.Endpoint, KeyID: "v2"},
+		{Key: "persisted", At: fake.Now(), Endpoint: "https://test-ns.com/ns/other", Keys: persisted},
+	}
+	for _, job := range jobs {
+		if err := store.Put(ctx, job); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	results := make(chan error, len(jobs))
+	scheduler := New(sender, store, &Options{
+		Clock:         fake,
+		Subscriptions: subscriptions,
+		OnResult: func(job *Job, res *webpush.SendResult, err error) {
+			results <- err
+		},
+	})
+
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		scheduler.Run(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	for range jobs {
+		if err := <-results; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sender.mu.Lock()
+	defer sender.mu.Unlock()
+
+	keys := map[string]webpush.SubscriptionKeys{}
+	for _, info := range sender.infos {
+		keys[info.Subscription.Endpoint] = info.Subscription.Keys
+		if info.Subscription.Endpoint == sub.Endpoint && info.Subscription.KeyID != "v2" {
+			t.Fatal("Key id was not kept", info.Subscription.KeyID)
+		}
+	}
+
+	if keys[sub.Endpoint] != sub.Keys || keys["https://test-ns.com/ns/other"] != persisted {
+		t.Fatalf("Unexpected sends %+v", sender.infos)
+	}
+}
+
+type blockingSender struct {
+	mu      sync.Mutex
+	active  int
+	maxSeen int
+	release chan struct{}
+}
+
+func (s *blockingSender) SendWithResult(ctx context.Context, payload []byte, info *webpush.WebPushInfo, options *webpush.WebPushOptions) (*webpush.SendResult, error) {
+	s.mu.Lock()
+	s.active++
+	s.maxSeen = max(s.maxSeen, s.active)
+	s.mu.Unlock()
+
+	<-s.release
+
+	s.mu.Lock()
+	s.active--
+	s.mu.Unlock()
+
+	return &webpush.SendResult{}, nil
+}
+
+func TestSchedulerWorkers(t *testing.T) {
+	fake := clock.NewFake(time.Unix(1710588595, 0))
+	sender := &blockingSender{release: make(chan struct{})}
+
+	results := make(chan error, 8)
+	scheduler := New(sender, nil, &Options{
+		Workers: 2,
+		Clock:   fake,
+		OnResult: func(job *Job, res *webpush.SendResult, err error) {
+			results <- err
+		},
+	})
+
+	ctx := context.Background()
+	for i := range 8 {
+		if err := scheduler.Schedule(ctx, strconv.Itoa(i), fake.Now(), nil, &webpush.WebPushInfo{}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		scheduler.Run(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	for range 8 {
+		sender.release <- struct{}{}
+		if err := <-results; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sender.mu.Lock()
+	defer sender.mu.Unlock()
+
+	if sender.maxSeen > 2 {
+		t.Fatal("Too many concurrent sends", sender.maxSeen)
+	}
+}
+
+func TestNextLocalTime(t *testing.T) {
+	loc := time.FixedZone("UTC+3", 3*60*60)
+	now := time.Date(2024, 3, 16, 7, 0, 0, 0, time.UTC)
+
+	if at := NextLocalTime(now, loc, 9, 0); !at.Equal(time.Date(2024, 3, 17, 6, 0, 0, 0, time.UTC)) {
+		t.Fatal("Unexpected time", at)
+	}
+
+	if at := NextLocalTime(now, loc, 11, 0); !at.Equal(time.Date(2024, 3, 16, 8, 0, 0, 0, time.UTC)) {
+		t.Fatal("Unexpected time", at)
+	}
+}
+
+type httpClientMock struct {
+	mu  sync.Mutex
+	ttl []string
+}
+
+func (c *httpClientMock) Do(req *http.Request) (*http.Response, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.ttl = append(c.ttl, req.Header.Get("TTL"))
+
+	return &http.Response{
+		StatusCode: 201,
+		Body:       io.NopCloser(bytes.NewReader([]byte{})),
+	}, nil
+}
+
+func (c *httpClientMock) TTL() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]string(nil), c.ttl...)
+}
+
+type clientSender struct {
+	client *webpush.WebPushClient
+}
+
+func (s *clientSender) SendWithResult(ctx context.Context, payload []byte, info *webpush.WebPushInfo, options *webpush.WebPushOptions) (*webpush.SendResult, error) {
+	return s.client.SendWithResult(ctx, payload, info, options)
+}
+
+func TestSchedulerAsDeferrer(t *testing.T) {
+	fake := clock.NewFake(time.Date(2024, 3, 16, 23, 0, 0, 0, time.UTC))
+	httpClient := &httpClientMock{}
+	sender := &clientSender{}
+	scheduler := New(sender, nil, &Options{Clock: fake})
+
+	sender.client = webpush.NewWebPushClient(httpClient, &auth.SimpleJwtSigner{Clock: fake}, &ece.Aes128GcmEncoder{},
+		webpush.WithClock(fake), webpush.WithDeferrer(scheduler))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		scheduler.Run(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	info := &webpush.WebPushInfo{
+		Subscription: webpush.Subscription{
+			Endpoint: "https://test-ns.com/ns/token",
+			Keys: webpush.SubscriptionKeys{
+				P256DH: "BFGGjgyqdoqg10kasOdjQ9M_XCGCUrHe9XdOtFtGgRQmxseX0rDCPnmkqUXK0sEhF30to0G4TonsvnxWq6BJrIA",
+				Auth:   "PVi3VfghXXXOELqDxy0oDA",
+			},
+		},
+		VapidDetails: webpush.VapidDetails{
+			Subject: "example@push.com",
+			VapidKeys: webpush.VapidKeys{
+				PrivateKey: "BdqJiVn-wHy0Jsr8kJ9kAceyuihPf31RiBP7SWtG5eU",
+				PublicKey:  "BC6EjsLzlGi7OaUSrB0MuURkbcdgq8XsTR3EwqwDhclzmh9xPCtpp50UCYgUV3IKwy3onLBhrtlWJktGzFapjGc",
+			},
+		},
+		Policy: &webpush.DeliveryPolicy{
+			Timezone:   "UTC",
+			QuietStart: webpush.TimeOfDay{Hour: 22},
+			QuietEnd:   webpush.TimeOfDay{Hour: 7},
+		},
+	}
+
+	res, err := sender.SendWithResult(ctx, []byte("Good morning"), info, &webpush.WebPushOptions{TTL: 86400})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Policy == nil || res.Policy.Action != webpush.PolicyDefer {
+		t.Fatal("Message was not deferred", res.Policy)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for fake.Waiters() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Scheduler is not waiting")
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	fake.Advance(8 * time.Hour)
+
+	for len(httpClient.TTL()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Deferred message was not sent")
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	if ttl := httpClient.TTL()[0]; ttl != "57600" {
+		t.Fatal("Unexpected TTL", ttl)
+	}
+}
@@ -0,0 +1,98 @@
+package schedule
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	webpush "github.com/Firebain/webpush-go"
+)
+
+// Job never holds VAPID keys. Per-call VapidDetails are kept in memory only,
+// so a job loaded by another process is signed through the client's tenant
+// registry, key ring or key provider.
+type Job struct {
+	Key      string                   `json:"key"`
+	At       time.Time                `json:"at"`
+	Created  time.Time                `json:"created"`
+	Payload  []byte                   `json:"payload"`
+	Endpoint string                   `json:"endpoint"`
+	Keys     webpush.SubscriptionKeys `json:"keys"`
+	KeyID    string                   `json:"keyId,omitempty"`
+	TenantID string                   `json:"tenantId,omitempty"`
+	Policy   *webpush.DeliveryPolicy  `json:"policy,omitempty"`
+	Options  *webpush.WebPushOptions  `json:"options,omitempty"`
+}
+
+type Store interface {
+	Put(ctx context.Context, job *Job) error
+	Delete(ctx context.Context, key string) (bool, error)
+	Claim(ctx context.Context, now time.Time) ([]*Job, error)
+	Next(ctx context.Context) (time.Time, bool, error)
+}
+
+type MemoryStore struct {
+	mu   sync.Mutex
+	jobs map[string]*Job
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		jobs: make(map[string]*Job),
+	}
+}
+
+func (s *MemoryStore) Put(ctx context.Context, job *Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.jobs[job.Key] = job
+
+	return nil
+}
+
+func (s *MemoryStore) Delete(ctx context.Context, key string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.jobs[key]
+	delete(s.jobs, key)
+
+	return ok, nil
+}
+
+func (s *MemoryStore) Claim(ctx context.Context, now time.Time) ([]*Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var due []*Job
+	for key, job := range s.jobs {
+		if !job.At.After(now) {
+			due = append(due, job)
+			delete(s.jobs, key)
+		}
+	}
+
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].At.Before(due[j].At)
+	})
+
+	return due, nil
+}
+
+func (s *MemoryStore) Next(ctx context.Context) (time.Time, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var next time.Time
+	found := false
+	for _, job := range s.jobs {
+		if !found || job.At.Before(next) {
+			next = job.At
+			found = true
+		}
+	}
+
+	return next, found, nil
+}
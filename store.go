@@ -0,0 +1,167 @@
+package webpush
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+)
+
+var ErrSubscriptionNotFound = errors.New("subscription not found")
+
+type SubscriptionRecord struct {
+	ID           string          `json:"id"`
+	Subscription Subscription    `json:"subscription"`
+	Tags         Tags            `json:"tags,omitempty"`
+	Policy       *DeliveryPolicy `json:"policy,omitempty"`
+}
+
+type Tags map[string][]string
+
+func (t Tags) Add(key string, value string) {
+	if !t.Has(key, value) {
+		t[key] = append(t[key], value)
+	}
+}
+
+func (t Tags) Set(key string, values ...string) {
+	t[key] = values
+}
+
+func (t Tags) Get(key string) string {
+	if values := t[key]; len(values) > 0 {
+		return values[0]
+	}
+
+	return ""
+}
+
+func (t Tags) Has(key string, value string) bool {
+	for _, v := range t[key] {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (t Tags) clone() Tags {
+	if t == nil {
+		return nil
+	}
+
+	cloned := make(Tags, len(t))
+	for key, values := range t {
+		cloned[key] = append([]string(nil), values...)
+	}
+
+	return cloned
+}
+
+func (r *SubscriptionRecord) clone() SubscriptionRecord {
+	cloned := *r
+	cloned.Tags = r.Tags.clone()
+
+	if r.Policy != nil {
+		policy := *r.Policy
+		cloned.Policy = &policy
+	}
+
+	return cloned
+}
+
+func NewSubscriptionRecord(sub Subscription) *SubscriptionRecord {
+	return &SubscriptionRecord{
+		ID:           sub.ID(),
+		Subscription: sub,
+	}
+}
+
+// SubscriptionStore.Range must not hold locks that fn could need.
+type SubscriptionStore interface {
+	Put(ctx context.Context, rec *SubscriptionRecord) error
+	Get(ctx context.Context, id string) (*SubscriptionRecord, error)
+	Delete(ctx context.Context, id string) (bool, error)
+	Range(ctx context.Context, fn func(rec *SubscriptionRecord) bool) error
+}
+
+type MemorySubscriptionStore struct {
+	mu      sync.RWMutex
+	records map[string]SubscriptionRecord
+}
+
+func NewMemorySubscriptionStore() *MemorySubscriptionStore {
+	return &MemorySubscriptionStore{
+		records: make(map[string]SubscriptionRecord),
+	}
+}
+
+func (s *MemorySubscriptionStore) Put(ctx context.Context, rec *SubscriptionRecord) error {
+	if rec.ID == "" {
+		return errors.New("subscription record has no id")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.records[rec.ID] = rec.clone()
+
+	return nil
+}
+
+func (s *MemorySubscriptionStore) Get(ctx context.Context, id string) (*SubscriptionRecord, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rec, ok := s.records[id]
+	if !ok {
+		return nil, ErrSubscriptionNotFound
+	}
+
+	cloned := rec.clone()
+
+	return &cloned, nil
+}
+
+func (s *MemorySubscriptionStore) Delete(ctx context.Context, id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.records[id]
+	delete(s.records, id)
+
+	return ok, nil
+}
+
+func (s *MemorySubscriptionStore) Range(ctx context.Context, fn func(rec *SubscriptionRecord) bool) error {
+	s.mu.RLock()
+	records := make([]SubscriptionRecord, 0, len(s.records))
+	for _, rec := range s.records {
+		records = append(records, rec.clone())
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].ID < records[j].ID
+	})
+
+	for i := range records {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if !fn(&records[i]) {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+func (s *MemorySubscriptionStore) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return len(s.records)
+}
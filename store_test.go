@@ -0,0 +1,40 @@
+package webpush
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestMemorySubscriptionStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemorySubscriptionStore()
+
+	sub := testInfo().Subscription
+	rec := NewSubscriptionRecord(sub)
+	if err := store.Put(ctx, rec); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := store.Get(ctx, sub.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded.Subscription.Endpoint = "https://test-ns.com/ns/changed"
+	if again, _ := store.Get(ctx, sub.ID()); again.Subscription != sub {
+		t.Fatal("Stored record was modified through a returned copy")
+	}
+
+	if err := store.Put(ctx, &SubscriptionRecord{Subscription: sub}); err == nil {
+		t.Fatal("Record without id was accepted")
+	}
+
+	if ok, err := store.Delete(ctx, sub.ID()); err != nil || !ok {
+		t.Fatal("Delete failed", ok, err)
+	}
+
+	if _, err := store.Get(ctx, sub.ID()); !errors.Is(err, ErrSubscriptionNotFound) {
+		t.Fatal("Expected ErrSubscriptionNotFound, got", err)
+	}
+}
@@ -1,5 +1,16 @@
 package webpush
 
+import (
+	"crypto/ecdh"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+
+	ibase64 "github.com/Firebain/webpush-go/internal/base64"
+)
+
 type SubscriptionKeys struct {
 	P256DH string `json:"p256dh"`
 	Auth   string `json:"auth"`
@@ -8,6 +19,48 @@ type SubscriptionKeys struct {
 type Subscription struct {
 	Endpoint string           `json:"endpoint"`
 	Keys     SubscriptionKeys `json:"keys"`
+	KeyID    string           `json:"keyId,omitempty"`
+}
+
+func (s *Subscription) ID() string {
+	hash := sha256.Sum256([]byte(s.Endpoint))
+
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
+
+func (s *Subscription) Validate() error {
+	endpoint, err := url.Parse(s.Endpoint)
+	if err != nil {
+		return err
+	}
+
+	if endpoint.Scheme != "https" || endpoint.Host == "" {
+		return errors.New("subscription endpoint is not an absolute https url")
+	}
+
+	if s.Keys.P256DH == "" || s.Keys.Auth == "" {
+		return errors.New("subscription keys are missing")
+	}
+
+	p256dh, err := ibase64.DecodeUrlBase64(s.Keys.P256DH)
+	if err != nil {
+		return errors.New("subscription p256dh key is not url-safe base64")
+	}
+
+	if _, err := ecdh.P256().NewPublicKey(p256dh); err != nil {
+		return errors.New("subscription p256dh key is not a P-256 public key")
+	}
+
+	auth, err := ibase64.DecodeUrlBase64(s.Keys.Auth)
+	if err != nil {
+		return errors.New("subscription auth secret is not url-safe base64")
+	}
+
+	if len(auth) != 16 {
+		return errors.New("subscription auth secret must be 16 bytes")
+	}
+
+	return nil
 }
 
 type VapidKeys struct {
@@ -23,4 +76,14 @@ type VapidDetails struct {
 type WebPushInfo struct {
 	Subscription Subscription
 	VapidDetails VapidDetails
+	TenantID     string
+	Policy       *DeliveryPolicy
+}
+
+type SendResult struct {
+	Response     *http.Response
+	Deduplicated bool
+	Handle       *MessageHandle
+	Attempts     int
+	Policy       *PolicyDecision
 }
@@ -0,0 +1,203 @@
+package webpush
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Firebain/webpush-go/auth"
+	"github.com/Firebain/webpush-go/clock"
+)
+
+var (
+	ErrUnknownTenant = errors.New("unknown tenant")
+	ErrRateLimited   = errors.New("tenant rate limit exceeded")
+)
+
+type Tenant struct {
+	ID           string
+	VapidDetails VapidDetails
+	RateLimit    float64
+	Burst        int
+	Options      *WebPushOptions
+}
+
+type tenantEntry struct {
+	tenant  Tenant
+	pair    *auth.VapidKeyPair
+	signer  *auth.CachedJwtSigner
+	limiter *rateLimiter
+}
+
+type TenantRegistry struct {
+	signerOptions []auth.CachedJwtSignerOption
+
+	mu      sync.RWMutex
+	tenants map[string]*tenantEntry
+}
+
+func NewTenantRegistry(signerOptions ...auth.CachedJwtSignerOption) *TenantRegistry {
+	return &TenantRegistry{
+		signerOptions: signerOptions,
+		tenants:       make(map[string]*tenantEntry),
+	}
+}
+
+func (r *TenantRegistry) Register(tenant Tenant) error {
+	if tenant.ID == "" {
+		return errors.New("tenant id is empty")
+	}
+
+	pair, err := tenant.VapidDetails.KeyPair()
+	if err != nil {
+		return err
+	}
+
+	entry := &tenantEntry{
+		tenant: tenant,
+		pair:   pair,
+		signer: auth.NewCachedJwtSigner(r.signerOptions...),
+	}
+
+	if tenant.RateLimit > 0 {
+		entry.limiter = newRateLimiter(tenant.RateLimit, tenant.Burst)
+	}
+
+	r.mu.Lock()
+	old := r.tenants[tenant.ID]
+	r.tenants[tenant.ID] = entry
+	r.mu.Unlock()
+
+	if old != nil {
+		old.signer.Close()
+	}
+
+	return nil
+}
+
+func (r *TenantRegistry) Remove(id string) {
+	r.mu.Lock()
+	entry := r.tenants[id]
+	delete(r.tenants, id)
+	r.mu.Unlock()
+
+	if entry != nil {
+		entry.signer.Close()
+	}
+}
+
+func (r *TenantRegistry) PublicKey(id string) (string, error) {
+	entry, err := r.entry(id)
+	if err != nil {
+		return "", err
+	}
+
+	return entry.pair.PublicKey(), nil
+}
+
+func (r *TenantRegistry) Stats(id string) (auth.CacheStats, error) {
+	entry, err := r.entry(id)
+	if err != nil {
+		return auth.CacheStats{}, err
+	}
+
+	return entry.signer.Stats(), nil
+}
+
+func (r *TenantRegistry) Close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, entry := range r.tenants {
+		entry.signer.Close()
+		delete(r.tenants, id)
+	}
+}
+
+func (r *TenantRegistry) entry(id string) (*tenantEntry, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	entry, ok := r.tenants[id]
+	if !ok {
+		return nil, ErrUnknownTenant
+	}
+
+	return entry, nil
+}
+
+func (r *TenantRegistry) middleware(clk clock.Clock) SendMiddleware {
+	return func(next SendFunc) SendFunc {
+		return func(ctx context.Context, msg *Message) (*SendResult, error) {
+			if msg.Info.TenantID == "" {
+				return next(ctx, msg)
+			}
+
+			entry, err := r.entry(msg.Info.TenantID)
+			if err != nil {
+				return nil, err
+			}
+
+			if entry.limiter != nil && !entry.limiter.allow(clk.Now()) {
+				return nil, ErrRateLimited
+			}
+
+			if msg.Options == nil && entry.tenant.Options != nil {
+				options := *entry.tenant.Options
+				msg.Options = &options
+			}
+
+			return next(ctx, msg)
+		}
+	}
+}
+
+func (c *WebPushClient) SendForTenant(ctx context.Context, tenantID string, subscription Subscription, payload []byte) (*http.Response, error) {
+	return c.SendWithContext(ctx, payload, &WebPushInfo{
+		Subscription: subscription,
+		TenantID:     tenantID,
+	}, nil)
+}
+
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &rateLimiter{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+	}
+}
+
+func (l *rateLimiter) allow(now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.last.IsZero() {
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+	}
+	l.last = now
+
+	if l.tokens < 1 {
+		return false
+	}
+
+	l.tokens--
+
+	return true
+}
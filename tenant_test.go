@@ -0,0 +1,94 @@
+package webpush
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Firebain/webpush-go/auth"
+	"github.com/Firebain/webpush-go/clock"
+	"github.com/Firebain/webpush-go/ece"
+)
+
+func TestTenantRegistry(t *testing.T) {
+	fake := clock.NewFake(time.Unix(1710588595, 0))
+	registry := NewTenantRegistry(auth.WithCacheClock(fake))
+	defer registry.Close()
+
+	tenants := map[string]*VapidKeys{}
+	for _, id := range []string{"acme", "globex"} {
+		keys, err := GenerateVapidKeys()
+		if err != nil {
+			t.Fatal(err)
+		}
+		tenants[id] = keys
+
+		err = registry.Register(Tenant{
+			ID:           id,
+			VapidDetails: VapidDetails{Subject: id + "@push.com", VapidKeys: *keys},
+			RateLimit:    1,
+			Burst:        2,
+			Options:      &WebPushOptions{TTL: 120, Urgency: "low"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	client := clientMock{}
+	webpush := NewWebPushClient(&client, &auth.SimpleJwtSigner{}, &ece.Aes128GcmEncoder{}, WithTenantRegistry(registry), WithClock(fake))
+
+	sub := testInfo().Subscription
+
+	for _, id := range []string{"acme", "globex", "acme"} {
+		res, err := webpush.SendForTenant(context.Background(), id, sub, []byte("Hello World!"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+
+		header := client.Request.Header
+		if !strings.HasSuffix(header.Get("Authorization"), "k="+tenants[id].PublicKey) {
+			t.Fatal("Wrong key used for tenant", id)
+		}
+
+		if header.Get("TTL") != "120" || header.Get("Urgency") != "low" {
+			t.Fatal("Tenant defaults were not applied", header)
+		}
+	}
+
+	t.Run("Separate caches", func(t *testing.T) {
+		stats, err := registry.Stats("acme")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if stats.Misses != 1 || stats.Hits != 1 {
+			t.Fatalf("Unexpected stats %+v", stats)
+		}
+	})
+
+	t.Run("Rate limit", func(t *testing.T) {
+		_, err := webpush.SendForTenant(context.Background(), "acme", sub, []byte("Hello World!"))
+		if !errors.Is(err, ErrRateLimited) {
+			t.Fatal("Rate limit was not applied", err)
+		}
+
+		fake.Advance(time.Second)
+
+		res, err := webpush.SendForTenant(context.Background(), "acme", sub, []byte("Hello World!"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+	})
+
+	t.Run("Unknown tenant", func(t *testing.T) {
+		_, err := webpush.SendForTenant(context.Background(), "initech", sub, []byte("Hello World!"))
+		if !errors.Is(err, ErrUnknownTenant) {
+			t.Fatal("Unknown tenant was accepted", err)
+		}
+	})
+}
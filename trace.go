@@ -0,0 +1,72 @@
+package webpush
+
+import (
+	"context"
+	"net/http/httptrace"
+	"time"
+
+	"github.com/Firebain/webpush-go/ece"
+)
+
+type VapidHeaderInfo struct {
+	Cached   bool
+	Duration time.Duration
+}
+
+type EncryptedInfo struct {
+	Size     int
+	Duration time.Duration
+}
+
+type ResponseInfo struct {
+	StatusCode int
+	Duration   time.Duration
+}
+
+type ClientTrace struct {
+	SubscriptionParsed  func(d time.Duration)
+	VapidHeaderObtained func(info VapidHeaderInfo)
+	KeyDerived          func(d time.Duration)
+	Encrypted           func(info EncryptedInfo)
+	RequestWritten      func(d time.Duration, err error)
+	ResponseReceived    func(info ResponseInfo)
+}
+
+type clientTraceKey struct{}
+
+func WithClientTrace(ctx context.Context, trace *ClientTrace) context.Context {
+	return context.WithValue(ctx, clientTraceKey{}, trace)
+}
+
+func ContextClientTrace(ctx context.Context) *ClientTrace {
+	trace, _ := ctx.Value(clientTraceKey{}).(*ClientTrace)
+
+	return trace
+}
+
+func (t *ClientTrace) encryptTrace() *ece.EncryptTrace {
+	if t == nil || (t.KeyDerived == nil && t.Encrypted == nil) {
+		return nil
+	}
+
+	return &ece.EncryptTrace{
+		KeyDerived: t.KeyDerived,
+		Encrypted: func(d time.Duration, size int) {
+			if t.Encrypted != nil {
+				t.Encrypted(EncryptedInfo{Size: size, Duration: d})
+			}
+		},
+	}
+}
+
+func (t *ClientTrace) httpTrace(ctx context.Context, start time.Time) context.Context {
+	if t == nil || t.RequestWritten == nil {
+		return ctx
+	}
+
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		WroteRequest: func(info httptrace.WroteRequestInfo) {
+			t.RequestWritten(time.Since(start), info.Err)
+		},
+	})
+}
@@ -0,0 +1,75 @@
+package webpush
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Firebain/webpush-go/auth"
+	"github.com/Firebain/webpush-go/ece"
+)
+
+func TestClientTrace(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	signer := auth.NewCachedJwtSigner()
+	defer signer.Close()
+
+	webpush := NewWebPushClient(server.Client(), signer, &ece.Aes128GcmEncoder{})
+
+	info := testInfo()
+	info.Subscription.Endpoint = server.URL + "/push/token"
+
+	var phases []string
+	var cached []bool
+	trace := &ClientTrace{
+		SubscriptionParsed: func(d time.Duration) { phases = append(phases, "parsed") },
+		VapidHeaderObtained: func(info VapidHeaderInfo) {
+			phases = append(phases, "vapid")
+			cached = append(cached, info.Cached)
+		},
+		KeyDerived: func(d time.Duration) { phases = append(phases, "derived") },
+		Encrypted: func(info EncryptedInfo) {
+			phases = append(phases, "encrypted")
+			if info.Size == 0 {
+				t.Error("Encrypted size is zero")
+			}
+		},
+		RequestWritten: func(d time.Duration, err error) { phases = append(phases, "written") },
+		ResponseReceived: func(info ResponseInfo) {
+			phases = append(phases, "response")
+			if info.StatusCode != http.StatusCreated {
+				t.Error("Unexpected status", info.StatusCode)
+			}
+		},
+	}
+
+	ctx := WithClientTrace(context.Background(), trace)
+	for range 2 {
+		res, err := webpush.SendWithContext(ctx, []byte("Hello World!"), info, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+	}
+
+	expected := []string{"parsed", "vapid", "derived", "encrypted", "written", "response"}
+	if len(phases) != 2*len(expected) {
+		t.Fatal("Unexpected phases", phases)
+	}
+
+	for i, phase := range phases {
+		if phase != expected[i%len(expected)] {
+			t.Fatal("Unexpected phases", phases)
+		}
+	}
+
+	if cached[0] || !cached[1] {
+		t.Fatal("Unexpected cache flags", cached)
+	}
+}
@@ -0,0 +1,317 @@
+package webpush
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+)
+
+type SubscriptionFormat int
+
+const (
+	FormatJSON SubscriptionFormat = iota
+	FormatJSONLines
+	FormatCSV
+)
+
+// csvHeader names the columns; tags are written in URL query encoding.
+var csvHeader = []string{"endpoint", "p256dh", "auth", "key_id", "tags"}
+
+type RejectedRow struct {
+	Row      int
+	Endpoint string
+	Err      error
+}
+
+func (r RejectedRow) Error() string {
+	return fmt.Sprintf("row %d: %v", r.Row, r.Err)
+}
+
+type ImportReport struct {
+	Imported   int
+	Duplicates int
+	Rejected   []RejectedRow
+}
+
+// transferRecord also accepts the output of PushSubscription.toJSON().
+type transferRecord struct {
+	Subscription *Subscription    `json:"subscription"`
+	Endpoint     string           `json:"endpoint"`
+	Keys         SubscriptionKeys `json:"keys"`
+	KeyID        string           `json:"keyId"`
+	Tags         Tags             `json:"tags"`
+	Policy       *DeliveryPolicy  `json:"policy"`
+}
+
+func (r *transferRecord) record() *SubscriptionRecord {
+	sub := Subscription{
+		Endpoint: r.Endpoint,
+		Keys:     r.Keys,
+		KeyID:    r.KeyID,
+	}
+	if r.Subscription != nil {
+		sub = *r.Subscription
+	}
+
+	rec := NewSubscriptionRecord(sub)
+	rec.Tags = r.Tags
+	rec.Policy = r.Policy
+
+	return rec
+}
+
+// ExportSubscriptions writes no delivery policies in CSV output.
+func ExportSubscriptions(ctx context.Context, w io.Writer, store SubscriptionStore, format SubscriptionFormat) (int, error) {
+	var (
+		write  func(rec *SubscriptionRecord) error
+		finish func() error
+	)
+
+	switch format {
+	case FormatJSON, FormatJSONLines:
+		buf := bufio.NewWriter(w)
+		separator := "["
+		if format == FormatJSONLines {
+			separator = ""
+		}
+
+		write = func(rec *SubscriptionRecord) error {
+			data, err := json.Marshal(rec)
+			if err != nil {
+				return err
+			}
+
+			buf.WriteString(separator)
+			buf.Write(data)
+
+			if format == FormatJSONLines {
+				buf.WriteByte('\n')
+			} else {
+				separator = ","
+			}
+
+			return nil
+		}
+
+		finish = func() error {
+			if format == FormatJSON {
+				if separator == "[" {
+					buf.WriteString("[")
+				}
+
+				buf.WriteString("]\n")
+			}
+
+			return buf.Flush()
+		}
+	case FormatCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(csvHeader); err != nil {
+			return 0, err
+		}
+
+		write = func(rec *SubscriptionRecord) error {
+			sub := &rec.Subscription
+
+			return cw.Write([]string{sub.Endpoint, sub.Keys.P256DH, sub.Keys.Auth, sub.KeyID, url.Values(rec.Tags).Encode()})
+		}
+
+		finish = func() error {
+			cw.Flush()
+
+			return cw.Error()
+		}
+	default:
+		return 0, errors.New("unknown subscription format")
+	}
+
+	count := 0
+	var writeErr error
+
+	err := store.Range(ctx, func(rec *SubscriptionRecord) bool {
+		if writeErr = write(rec); writeErr != nil {
+			return false
+		}
+
+		count++
+
+		return true
+	})
+	if err != nil {
+		return count, err
+	}
+
+	if writeErr != nil {
+		return count, writeErr
+	}
+
+	return count, finish()
+}
+
+// ImportSubscriptions reports invalid rows instead of failing. The first
+// occurrence of an endpoint wins.
+func ImportSubscriptions(ctx context.Context, r io.Reader, store SubscriptionStore, format SubscriptionFormat) (*ImportReport, error) {
+	report := &ImportReport{}
+	seen := make(map[string]struct{})
+
+	add := func(row int, rec *SubscriptionRecord) error {
+		err := rec.Subscription.Validate()
+		if err == nil && rec.Policy != nil {
+			err = rec.Policy.Validate()
+		}
+
+		if err != nil {
+			report.Rejected = append(report.Rejected, RejectedRow{Row: row, Endpoint: rec.Subscription.Endpoint, Err: err})
+			return nil
+		}
+
+		if _, ok := seen[rec.ID]; ok {
+			report.Duplicates++
+			return nil
+		}
+
+		seen[rec.ID] = struct{}{}
+
+		if err := store.Put(ctx, rec); err != nil {
+			return err
+		}
+
+		report.Imported++
+
+		return ctx.Err()
+	}
+
+	reject := func(row int, err error) {
+		report.Rejected = append(report.Rejected, RejectedRow{Row: row, Err: err})
+	}
+
+	switch format {
+	case FormatJSON:
+		dec := json.NewDecoder(r)
+		if _, err := dec.Token(); err != nil {
+			return report, err
+		}
+
+		for row := 1; dec.More(); row++ {
+			var tr transferRecord
+			if err := dec.Decode(&tr); err != nil {
+				var typeErr *json.UnmarshalTypeError
+				if !errors.As(err, &typeErr) {
+					return report, err
+				}
+
+				reject(row, err)
+				continue
+			}
+
+			if err := add(row, tr.record()); err != nil {
+				return report, err
+			}
+		}
+
+		if _, err := dec.Token(); err != nil {
+			return report, err
+		}
+	case FormatJSONLines:
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+		for row := 1; scanner.Scan(); row++ {
+			line := scanner.Bytes()
+			if len(line) == 0 {
+				continue
+			}
+
+			var tr transferRecord
+			if err := json.Unmarshal(line, &tr); err != nil {
+				reject(row, err)
+				continue
+			}
+
+			if err := add(row, tr.record()); err != nil {
+				return report, err
+			}
+		}
+
+		if err := scanner.Err(); err != nil {
+			return report, err
+		}
+	case FormatCSV:
+		cr := csv.NewReader(r)
+		cr.FieldsPerRecord = -1
+
+		header, err := cr.Read()
+		if err != nil {
+			return report, err
+		}
+
+		columns := make(map[string]int, len(header))
+		for i, name := range header {
+			columns[name] = i
+		}
+
+		for _, name := range csvHeader[:3] {
+			if _, ok := columns[name]; !ok {
+				return report, fmt.Errorf("csv header has no %q column", name)
+			}
+		}
+
+		field := func(fields []string, name string) string {
+			i, ok := columns[name]
+			if !ok || i >= len(fields) {
+				return ""
+			}
+
+			return fields[i]
+		}
+
+		for row := 1; ; row++ {
+			fields, err := cr.Read()
+			if err == io.EOF {
+				break
+			}
+
+			if err != nil {
+				var parseErr *csv.ParseError
+				if !errors.As(err, &parseErr) {
+					return report, err
+				}
+
+				reject(row, err)
+				continue
+			}
+
+			rec := NewSubscriptionRecord(Subscription{
+				Endpoint: field(fields, "endpoint"),
+				Keys: SubscriptionKeys{
+					P256DH: field(fields, "p256dh"),
+					Auth:   field(fields, "auth"),
+				},
+				KeyID: field(fields, "key_id"),
+			})
+
+			if encoded := field(fields, "tags"); encoded != "" {
+				tags, err := url.ParseQuery(encoded)
+				if err != nil {
+					reject(row, err)
+					continue
+				}
+
+				rec.Tags = Tags(tags)
+			}
+
+			if err := add(row, rec); err != nil {
+				return report, err
+			}
+		}
+	default:
+		return report, errors.New("unknown subscription format")
+	}
+
+	return report, nil
+}
@@ -0,0 +1,151 @@
+package webpush
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func testStore(t *testing.T, n int) *MemorySubscriptionStore {
+	t.Helper()
+
+	store := NewMemorySubscriptionStore()
+	for i := 0; i < n; i++ {
+		sub := testInfo().Subscription
+		sub.Endpoint = fmt.Sprintf("https://test-ns.com/ns/token-%d", i)
+		sub.KeyID = "v1"
+
+		rec := NewSubscriptionRecord(sub)
+		rec.Tags = Tags{"topic": {"news", "sports"}, "user": {fmt.Sprint(i)}}
+
+		if err := store.Put(context.Background(), rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return store
+}
+
+func TestSubscriptionTransfer(t *testing.T) {
+	ctx := context.Background()
+	source := testStore(t, 3)
+
+	for _, format := range []SubscriptionFormat{FormatJSON, FormatJSONLines, FormatCSV} {
+		t.Run(fmt.Sprint("Round trip ", format), func(t *testing.T) {
+			var buf bytes.Buffer
+
+			count, err := ExportSubscriptions(ctx, &buf, source, format)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if count != 3 {
+				t.Fatal("Unexpected export count", count)
+			}
+
+			target := NewMemorySubscriptionStore()
+			report, err := ImportSubscriptions(ctx, &buf, target, format)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if report.Imported != 3 || report.Duplicates != 0 || len(report.Rejected) != 0 {
+				t.Fatal("Unexpected report", report)
+			}
+
+			if err := source.Range(ctx, func(rec *SubscriptionRecord) bool {
+				loaded, err := target.Get(ctx, rec.ID)
+				if err != nil || loaded.Subscription != rec.Subscription {
+					t.Fatal("Record was not imported", rec.ID, err)
+				}
+
+				if fmt.Sprint(loaded.Tags) != fmt.Sprint(rec.Tags) {
+					t.Fatal("Tags were not imported", loaded.Tags)
+				}
+
+				return true
+			}); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+
+	t.Run("Empty JSON export", func(t *testing.T) {
+		var buf bytes.Buffer
+		if _, err := ExportSubscriptions(ctx, &buf, NewMemorySubscriptionStore(), FormatJSON); err != nil {
+			t.Fatal(err)
+		}
+
+		if buf.String() != "[]\n" {
+			t.Fatal("Unexpected output", buf.String())
+		}
+	})
+
+	sub := testInfo().Subscription
+	valid := fmt.Sprintf(`{"endpoint": %q, "keys": {"p256dh": %q, "auth": %q}}`, sub.Endpoint, sub.Keys.P256DH, sub.Keys.Auth)
+
+	t.Run("Bad rows and duplicates in JSON lines", func(t *testing.T) {
+		input := strings.Join([]string{
+			valid,
+			`{"endpoint": "http://test-ns.com/insecure", "keys": {"p256dh": "` + sub.Keys.P256DH + `", "auth": "` + sub.Keys.Auth + `"}}`,
+			`not json`,
+			valid,
+			`{"endpoint": "https://test-ns.com/short", "keys": {"p256dh": "` + sub.Keys.P256DH + `", "auth": "AAAA"}}`,
+		}, "\n")
+
+		report, err := ImportSubscriptions(ctx, strings.NewReader(input), NewMemorySubscriptionStore(), FormatJSONLines)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if report.Imported != 1 || report.Duplicates != 1 {
+			t.Fatal("Unexpected report", report)
+		}
+
+		var rows []int
+		for _, rejected := range report.Rejected {
+			rows = append(rows, rejected.Row)
+		}
+
+		if fmt.Sprint(rows) != "[2 3 5]" {
+			t.Fatal("Unexpected rejected rows", rows)
+		}
+	})
+
+	t.Run("Type errors in a JSON array", func(t *testing.T) {
+		input := `[` + valid + `, {"endpoint": 42}]`
+
+		report, err := ImportSubscriptions(ctx, strings.NewReader(input), NewMemorySubscriptionStore(), FormatJSON)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if report.Imported != 1 || len(report.Rejected) != 1 || report.Rejected[0].Row != 2 {
+			t.Fatal("Unexpected report", report)
+		}
+	})
+
+	t.Run("CSV with reordered columns", func(t *testing.T) {
+		input := "auth,endpoint,p256dh\n" + sub.Keys.Auth + "," + sub.Endpoint + "," + sub.Keys.P256DH + "\n,,\n"
+
+		store := NewMemorySubscriptionStore()
+		report, err := ImportSubscriptions(ctx, strings.NewReader(input), store, FormatCSV)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if report.Imported != 1 || len(report.Rejected) != 1 || report.Rejected[0].Row != 2 {
+			t.Fatal("Unexpected report", report)
+		}
+
+		if _, err := store.Get(ctx, sub.ID()); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := ImportSubscriptions(ctx, strings.NewReader("endpoint,auth\n"), store, FormatCSV); err == nil {
+			t.Fatal("Header without p256dh was accepted")
+		}
+	})
+}
@@ -1,9 +1,14 @@
 package webpush
 
 import (
+	"container/list"
 	"crypto/ecdh"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
+	"sync"
+
+	"github.com/Firebain/webpush-go/auth"
 )
 
 func GenerateVapidKeys() (*VapidKeys, error) {
@@ -20,3 +25,66 @@ func GenerateVapidKeys() (*VapidKeys, error) {
 		PublicKey:  public,
 	}, nil
 }
+
+func (k *VapidKeys) Validate() error {
+	_, err := k.KeyPair()
+
+	return err
+}
+
+func (k *VapidKeys) KeyPair() (*auth.VapidKeyPair, error) {
+	return auth.NewVapidKeyPair(k.PrivateKey, k.PublicKey)
+}
+
+const maxCachedKeyPairs = 64
+
+type cachedKeyPair struct {
+	id   [sha256.Size]byte
+	pair *auth.VapidKeyPair
+}
+
+type keyPairCache struct {
+	mu    sync.Mutex
+	lru   *list.List
+	pairs map[[sha256.Size]byte]*list.Element
+}
+
+func newKeyPairCache() *keyPairCache {
+	return &keyPairCache{
+		lru:   list.New(),
+		pairs: make(map[[sha256.Size]byte]*list.Element),
+	}
+}
+
+func (c *keyPairCache) get(keys *VapidKeys) (*auth.VapidKeyPair, error) {
+	id := sha256.Sum256([]byte(keys.PrivateKey + "." + keys.PublicKey))
+
+	c.mu.Lock()
+	if elem, ok := c.pairs[id]; ok {
+		c.lru.MoveToFront(elem)
+		c.mu.Unlock()
+
+		return elem.Value.(*cachedKeyPair).pair, nil
+	}
+	c.mu.Unlock()
+
+	pair, err := keys.KeyPair()
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.pairs[id]; !ok {
+		c.pairs[id] = c.lru.PushFront(&cachedKeyPair{id: id, pair: pair})
+	}
+
+	for c.lru.Len() > maxCachedKeyPairs {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.pairs, oldest.Value.(*cachedKeyPair).id)
+	}
+
+	return pair, nil
+}
@@ -0,0 +1,311 @@
+package webpush
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+
+	ibase64 "github.com/Firebain/webpush-go/internal/base64"
+)
+
+type KeyFormat int
+
+const (
+	FormatSEC1 KeyFormat = iota
+	FormatPKCS8
+)
+
+const (
+	pemTypeSEC1  = "EC PRIVATE KEY"
+	pemTypePKCS8 = "PRIVATE KEY"
+)
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	D   string `json:"d,omitempty"`
+}
+
+func ParseVapidKeysPEM(data []byte) (*VapidKeys, error) {
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil, errors.New("no private key found in pem data")
+		}
+
+		switch block.Type {
+		case pemTypeSEC1:
+			return parseSEC1(block.Bytes)
+		case pemTypePKCS8:
+			return parsePKCS8(block.Bytes)
+		}
+	}
+}
+
+func ParseVapidKeysDER(der []byte) (*VapidKeys, error) {
+	if keys, err := parsePKCS8(der); err == nil {
+		return keys, nil
+	}
+
+	return parseSEC1(der)
+}
+
+func ParseVapidKeysJWK(data []byte) (*VapidKeys, error) {
+	var jwk jsonWebKey
+	if err := json.Unmarshal(data, &jwk); err != nil {
+		return nil, err
+	}
+
+	if jwk.Kty != "EC" || jwk.Crv != "P-256" {
+		return nil, errors.New("jwk is not a P-256 key")
+	}
+
+	if jwk.D == "" {
+		return nil, errors.New("jwk has no private key")
+	}
+
+	x, err := ibase64.DecodeUrlBase64(jwk.X)
+	if err != nil {
+		return nil, err
+	}
+
+	y, err := ibase64.DecodeUrlBase64(jwk.Y)
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := ibase64.DecodeUrlBase64(jwk.D)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(x) != 32 || len(y) != 32 {
+		return nil, errors.New("invalid jwk coordinates")
+	}
+
+	public := make([]byte, 0, 65)
+	public = append(public, 0x04)
+	public = append(public, x...)
+	public = append(public, y...)
+
+	return vapidKeysFromBytes(d, public)
+}
+
+func (k *VapidKeys) EncodeDER(format KeyFormat) ([]byte, error) {
+	key, err := k.ecdhKey()
+	if err != nil {
+		return nil, err
+	}
+
+	switch format {
+	case FormatSEC1:
+		x, y := elliptic.Unmarshal(elliptic.P256(), key.PublicKey().Bytes())
+
+		return x509.MarshalECPrivateKey(&ecdsa.PrivateKey{
+			PublicKey: ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y},
+			D:         new(big.Int).SetBytes(key.Bytes()),
+		})
+	case FormatPKCS8:
+		return x509.MarshalPKCS8PrivateKey(key)
+	default:
+		return nil, errors.New("unknown key format")
+	}
+}
+
+func (k *VapidKeys) EncodePEM(format KeyFormat) ([]byte, error) {
+	der, err := k.EncodeDER(format)
+	if err != nil {
+		return nil, err
+	}
+
+	blockType := pemTypeSEC1
+	if format == FormatPKCS8 {
+		blockType = pemTypePKCS8
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), nil
+}
+
+func (k *VapidKeys) EncodeJWK() ([]byte, error) {
+	key, err := k.ecdhKey()
+	if err != nil {
+		return nil, err
+	}
+
+	public := key.PublicKey().Bytes()
+
+	return json.Marshal(jsonWebKey{
+		Kty: "EC",
+		Crv: "P-256",
+		X:   base64.RawURLEncoding.EncodeToString(public[1:33]),
+		Y:   base64.RawURLEncoding.EncodeToString(public[33:]),
+		D:   base64.RawURLEncoding.EncodeToString(key.Bytes()),
+	})
+}
+
+func (k *VapidKeys) ecdhKey() (*ecdh.PrivateKey, error) {
+	private, err := ibase64.DecodeUrlBase64(k.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	public, err := ibase64.DecodeUrlBase64(k.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := vapidKeysFromBytes(private, public); err != nil {
+		return nil, err
+	}
+
+	return ecdh.P256().NewPrivateKey(private)
+}
+
+func vapidKeysFromBytes(private []byte, public []byte) (*VapidKeys, error) {
+	key, err := ecdh.P256().NewPrivateKey(private)
+	if err != nil {
+		return nil, err
+	}
+
+	if public != nil && !bytes.Equal(key.PublicKey().Bytes(), public) {
+		return nil, errors.New("public key does not match private key")
+	}
+
+	return &VapidKeys{
+		PrivateKey: base64.RawURLEncoding.EncodeToString(key.Bytes()),
+		PublicKey:  base64.RawURLEncoding.EncodeToString(key.PublicKey().Bytes()),
+	}, nil
+}
+
+func parseSEC1(der []byte) (*VapidKeys, error) {
+	if err := checkTrailingData(der); err != nil {
+		return nil, err
+	}
+
+	key, err := x509.ParseECPrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkEmbeddedPublicKey(der, key); err != nil {
+		return nil, err
+	}
+
+	return ecdsaVapidKeys(key)
+}
+
+func parsePKCS8(der []byte) (*VapidKeys, error) {
+	if err := checkTrailingData(der); err != nil {
+		return nil, err
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+
+	key, ok := parsed.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("key is not an ec key")
+	}
+
+	var info pkcs8
+	if _, err := asn1.Unmarshal(der, &info); err != nil {
+		return nil, err
+	}
+
+	if err := checkEmbeddedPublicKey(info.PrivateKey, key); err != nil {
+		return nil, err
+	}
+
+	return ecdsaVapidKeys(key)
+}
+
+type ecPrivateKey struct {
+	Version       int
+	PrivateKey    []byte
+	NamedCurveOID asn1.ObjectIdentifier `asn1:"optional,explicit,tag:0"`
+	PublicKey     asn1.BitString        `asn1:"optional,explicit,tag:1"`
+}
+
+type pkcs8 struct {
+	Version    int
+	Algo       pkix.AlgorithmIdentifier
+	PrivateKey []byte
+	Attributes asn1.RawValue `asn1:"optional,tag:0"`
+}
+
+// checkEmbeddedPublicKey compares the optional public key of a SEC1
+// structure with the one derived from the scalar. The x509 parsers ignore it.
+func checkEmbeddedPublicKey(sec1 []byte, key *ecdsa.PrivateKey) error {
+	var parsed ecPrivateKey
+	if _, err := asn1.Unmarshal(sec1, &parsed); err != nil {
+		return err
+	}
+
+	embedded := parsed.PublicKey.RightAlign()
+	if len(embedded) == 0 {
+		return nil
+	}
+
+	if x, y := elliptic.UnmarshalCompressed(elliptic.P256(), embedded); x != nil {
+		embedded = elliptic.Marshal(elliptic.P256(), x, y)
+	}
+
+	public, err := key.PublicKey.ECDH()
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(embedded, public.Bytes()) {
+		return errors.New("embedded public key does not match private key")
+	}
+
+	return nil
+}
+
+// checkTrailingData rejects input the x509 parsers would silently ignore.
+func checkTrailingData(der []byte) error {
+	var raw asn1.RawValue
+
+	rest, err := asn1.Unmarshal(der, &raw)
+	if err != nil {
+		return err
+	}
+
+	if len(rest) != 0 {
+		return errors.New("trailing data after private key")
+	}
+
+	return nil
+}
+
+func ecdsaVapidKeys(key *ecdsa.PrivateKey) (*VapidKeys, error) {
+	if key.Curve != elliptic.P256() {
+		return nil, errors.New("key is not on the P-256 curve")
+	}
+
+	private, err := key.ECDH()
+	if err != nil {
+		return nil, err
+	}
+
+	public, err := key.PublicKey.ECDH()
+	if err != nil {
+		return nil, err
+	}
+
+	return vapidKeysFromBytes(private.Bytes(), public.Bytes())
+}
@@ -1,14 +1,19 @@
 package webpush
 
 import (
-	"bytes"
 	"context"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"strconv"
+	"time"
 
 	"github.com/Firebain/webpush-go/auth"
+	"github.com/Firebain/webpush-go/clock"
 	"github.com/Firebain/webpush-go/ece"
+	"github.com/Firebain/webpush-go/metrics"
 )
 
 const DefaultTTL = 4 * 7 * 24 * 60 * 60
@@ -18,15 +23,34 @@ type HTTPClient interface {
 }
 
 type WebPushOptions struct {
-	Urgency string
-	Topic   string
-	TTL     int
+	Urgency        string
+	Topic          string
+	TTL            int
+	IdempotencyKey string
+	Collapsible    bool
+	Receipt        string
 }
 
 type WebPushClient struct {
 	httpClient HTTPClient
 	jwtSigner  auth.WebPushJwtSigner
 	encoder    ece.WebPushEncoder
+	keyPairs   *keyPairCache
+	keys       KeyProvider
+	keySources int
+	clock      clock.Clock
+	dedup      DedupStore
+	receipts   *ReceiptTracker
+	middleware []SendMiddleware
+	layers     []Layer
+	sendFunc   SendFunc
+	tenants    *TenantRegistry
+	counter    DeliveryCounter
+	deferrer   Deferrer
+
+	logger       *slog.Logger
+	logSensitive bool
+	metrics      metrics.Recorder
 }
 
 func DefaultWebPushClient() *WebPushClient {
@@ -41,71 +65,408 @@ func DefaultWebPushClient() *WebPushClient {
 	)
 }
 
-func NewWebPushClient(httpClient HTTPClient, jwtSigner auth.WebPushJwtSigner, encoder ece.WebPushEncoder) *WebPushClient {
-	return &WebPushClient{
+// ErrConflictingKeySources is the panic value of NewWebPushClient when more
+// than one of WithKeyRing and WithKeyProvider is given.
+var ErrConflictingKeySources = errors.New("only one of WithKeyRing and WithKeyProvider can be used")
+
+func NewWebPushClient(httpClient HTTPClient, jwtSigner auth.WebPushJwtSigner, encoder ece.WebPushEncoder, opts ...ClientOption) *WebPushClient {
+	c := &WebPushClient{
 		httpClient: httpClient,
 		jwtSigner:  jwtSigner,
 		encoder:    encoder,
+		keyPairs:   newKeyPairCache(),
+		clock:      clock.System{},
 	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	if c.keySources > 1 {
+		panic(ErrConflictingKeySources)
+	}
+
+	if c.counter == nil {
+		c.counter = NewMemoryDeliveryCounter()
+	}
+
+	c.sendFunc = c.sendChain()
+
+	return c
 }
 
+// SendWithContext returns ErrDeduplicated, ErrMessageDeferred or
+// ErrMessageDropped when no request was made; use SendWithResult to inspect
+// those outcomes.
 func (c *WebPushClient) SendWithContext(ctx context.Context, payload []byte, info *WebPushInfo, options *WebPushOptions) (*http.Response, error) {
-	endpoint, err := url.Parse(info.Subscription.Endpoint)
+	res, err := c.SendWithResult(ctx, payload, info, options)
 	if err != nil {
 		return nil, err
 	}
 
-	vapidHeader, err := c.jwtSigner.VapidHeader(
-		endpoint,
-		info.VapidDetails.PrivateKey,
-		info.VapidDetails.PublicKey,
-		info.VapidDetails.Subject,
-	)
+	if res.Response == nil {
+		return nil, res.err()
+	}
+
+	return res.Response, nil
+}
+
+func (r *SendResult) err() error {
+	switch {
+	case r.Deduplicated:
+		return ErrDeduplicated
+	case r.Policy != nil && r.Policy.Action == PolicyDefer:
+		return ErrMessageDeferred
+	default:
+		return ErrMessageDropped
+	}
+}
+
+func (c *WebPushClient) SendWithResult(ctx context.Context, payload []byte, info *WebPushInfo, options *WebPushOptions) (*SendResult, error) {
+	ctx = contextWithLogger(ctx, c.logger)
+	ctx = contextWithMetrics(ctx, c.metrics)
+
+	return c.sendFunc(ctx, &Message{
+		Payload: payload,
+		Info:    info,
+		Options: options,
+	})
+}
+
+func (c *WebPushClient) deliver(ctx context.Context, msg *Message) (*SendResult, error) {
+	trace := ContextClientTrace(ctx)
+
+	var prepared *PreparedMessage
+	var err error
+	if msg.Prepared != nil {
+		prepared, err = c.renew(ctx, msg.Prepared, msg.Options)
+	} else {
+		prepared, err = c.prepare(ctx, msg.Payload, msg.Info, msg.Options)
+	}
+	if err != nil {
+		c.logFailure(ctx, "webpush prepare failed", msg, err)
+		recordOutcome(c.metrics, msg.Info.Subscription.Endpoint, outcomeInvalid)
+		return nil, err
+	}
+
+	start := time.Now()
+
+	req, err := prepared.Request(trace.httpTrace(ctx, start))
+	if err != nil {
+		return nil, err
+	}
+
+	if c.logger != nil {
+		c.logger.LogAttrs(ctx, slog.LevelDebug, "webpush send start",
+			slog.String("origin", endpointOrigin(prepared.Endpoint)),
+			c.logEndpoint(prepared.Endpoint),
+			slog.String("ttl", prepared.Headers.Get("TTL")),
+			slog.String("urgency", prepared.Headers.Get("Urgency")),
+			slog.Int("payload_size", len(msg.Payload)),
+			slog.Int("ciphertext_size", len(prepared.Body)),
+		)
+	}
+
+	origin := metrics.Labels{"origin": endpointOrigin(prepared.Endpoint)}
+	if c.metrics != nil {
+		c.metrics.Counter(metricSendAttempts, origin, 1)
+		c.metrics.Histogram(metricPayloadSize, origin, float64(len(msg.Payload)))
+		c.metrics.Histogram(metricCiphertextSize, origin, float64(len(prepared.Body)))
+	}
+
+	res, err := c.httpClient.Do(req)
+	duration := time.Since(start)
+
+	if c.metrics != nil {
+		c.metrics.Histogram(metricSendDuration, origin, duration.Seconds())
+	}
+
 	if err != nil {
+		c.logFailure(ctx, "webpush send failed", msg, err)
+		recordOutcome(c.metrics, prepared.Endpoint, outcomeError)
 		return nil, err
 	}
 
-	encrypted, err := c.encoder.EncryptPayload(
-		info.Subscription.Keys.P256DH,
-		info.Subscription.Keys.Auth,
-		[]byte(payload),
+	recordOutcome(c.metrics, prepared.Endpoint, statusOutcome(res.StatusCode))
+
+	if c.logger != nil {
+		level := slog.LevelInfo
+		if res.StatusCode >= 300 {
+			level = slog.LevelWarn
+		}
+
+		c.logger.LogAttrs(ctx, level, "webpush send finish",
+			slog.String("origin", endpointOrigin(prepared.Endpoint)),
+			c.logEndpoint(prepared.Endpoint),
+			slog.Int("status", res.StatusCode),
+			slog.Duration("duration", duration),
+		)
+	}
+
+	if trace != nil && trace.ResponseReceived != nil {
+		trace.ResponseReceived(ResponseInfo{StatusCode: res.StatusCode, Duration: duration})
+	}
+
+	handle := newMessageHandle(res, msg.Info, msg.Options)
+	if handle != nil && c.receipts != nil && msg.Options != nil && msg.Options.Receipt != "" {
+		c.receipts.Track(handle)
+	}
+
+	return &SendResult{
+		Response: res,
+		Handle:   handle,
+		Attempts: 1,
+	}, nil
+}
+
+func (c *WebPushClient) logFailure(ctx context.Context, event string, msg *Message, err error) {
+	if c.logger == nil {
+		return
+	}
+
+	c.logger.LogAttrs(ctx, slog.LevelError, event,
+		slog.String("origin", endpointOrigin(msg.Info.Subscription.Endpoint)),
+		c.logEndpoint(msg.Info.Subscription.Endpoint),
+		c.logError(err),
 	)
+}
+
+func (c *WebPushClient) BuildRequest(ctx context.Context, payload []byte, info *WebPushInfo, options *WebPushOptions) (*http.Request, error) {
+	msg, err := c.prepare(ctx, payload, info, options)
+	if err != nil {
+		return nil, err
+	}
+
+	return msg.Request(ctx)
+}
+
+func (c *WebPushClient) Prepare(payload []byte, info *WebPushInfo, options *WebPushOptions) (*PreparedMessage, error) {
+	return c.prepare(context.Background(), payload, info, options)
+}
+
+func (c *WebPushClient) prepare(ctx context.Context, payload []byte, info *WebPushInfo, options *WebPushOptions) (*PreparedMessage, error) {
+	trace := ContextClientTrace(ctx)
+	start := time.Now()
+
+	endpoint, err := url.Parse(info.Subscription.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	if trace != nil && trace.SubscriptionParsed != nil {
+		trace.SubscriptionParsed(time.Since(start))
+	}
+
+	identity, err := c.vapidIdentity(ctx, info)
 	if err != nil {
 		return nil, err
 	}
 
-	body := bytes.NewReader(encrypted)
+	start = time.Now()
+
+	vapidHeader, cached, err := identity.header(endpoint)
+	if err != nil {
+		return nil, err
+	}
 
-	req, err := http.NewRequestWithContext(ctx, "POST", info.Subscription.Endpoint, body)
+	if trace != nil && trace.VapidHeaderObtained != nil {
+		trace.VapidHeaderObtained(VapidHeaderInfo{Cached: cached, Duration: time.Since(start)})
+	}
+
+	var encrypted []byte
+	if encoder, ok := c.encoder.(ece.TracingEncoder); ok && trace != nil {
+		encrypted, err = encoder.EncryptPayloadWithTrace(
+			info.Subscription.Keys.P256DH,
+			info.Subscription.Keys.Auth,
+			payload,
+			trace.encryptTrace(),
+		)
+	} else {
+		encrypted, err = c.encoder.EncryptPayload(
+			info.Subscription.Keys.P256DH,
+			info.Subscription.Keys.Auth,
+			payload,
+		)
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	req.Header.Add("Authorization", vapidHeader)
-	req.Header.Add("Content-Type", "application/octet-stream")
-	req.Header.Add("Content-Length", strconv.Itoa(len(encrypted)))
-	req.Header.Add("Content-Encoding", "aes128gcm")
+	headers := http.Header{}
+	headers.Add("Authorization", vapidHeader)
+	headers.Add("Content-Type", "application/octet-stream")
+	headers.Add("Content-Length", strconv.Itoa(len(encrypted)))
+	headers.Add("Content-Encoding", "aes128gcm")
 
+	ttl := DefaultTTL
 	if options != nil {
 		if options.Urgency != "" {
-			req.Header.Add("Urgency", "normal")
+			headers.Add("Urgency", options.Urgency)
 		}
 
-		if options.Topic != "" {
-			req.Header.Add("Topic", "topic")
+		topic := options.Topic
+		if topic == "" && options.Collapsible && options.IdempotencyKey != "" {
+			topic = topicFromKey(options.IdempotencyKey)
 		}
 
-		req.Header.Add("TTL", strconv.Itoa(options.TTL))
-	} else {
-		req.Header.Add("TTL", strconv.Itoa(DefaultTTL))
+		if topic != "" {
+			headers.Add("Topic", topic)
+		}
+
+		if options.Receipt != "" {
+			headers.Add("Push-Receipt", options.Receipt)
+			headers.Add("Prefer", "respond-async")
+		}
+
+		ttl = options.TTL
 	}
 
-	res, err := c.httpClient.Do(req)
+	headers.Add("TTL", strconv.Itoa(ttl))
+
+	tokenExpires, _ := vapidExpiry(vapidHeader)
+
+	return &PreparedMessage{
+		Endpoint:     info.Subscription.Endpoint,
+		Headers:      headers,
+		Body:         encrypted,
+		Expires:      c.clock.Now().Add(time.Duration(ttl) * time.Second),
+		TokenExpires: tokenExpires,
+		KeyID:        info.Subscription.KeyID,
+		TenantID:     info.TenantID,
+		vapid:        info.VapidDetails,
+	}, nil
+}
+
+// SendPrepared runs the message through the same middleware as
+// SendWithContext, with the remaining TTL.
+func (c *WebPushClient) SendPrepared(ctx context.Context, msg *PreparedMessage) (*http.Response, error) {
+	now := c.clock.Now()
+	if msg.Expired(now) {
+		return nil, ErrMessageExpired
+	}
+
+	ctx = contextWithLogger(ctx, c.logger)
+	ctx = contextWithMetrics(ctx, c.metrics)
+
+	res, err := c.sendFunc(ctx, &Message{
+		Info:     msg.info(),
+		Options:  msg.options(now),
+		Prepared: msg,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if res.Response == nil {
+		return nil, res.err()
+	}
+
+	return res.Response, nil
+}
+
+// renew applies options changed by middleware and signs a new VAPID token
+// when the prepared one is about to expire.
+func (c *WebPushClient) renew(ctx context.Context, msg *PreparedMessage, options *WebPushOptions) (*PreparedMessage, error) {
+	renewed := *msg
+	renewed.Headers = msg.Headers.Clone()
+	if options != nil {
+		renewed.Headers.Set("TTL", strconv.Itoa(options.TTL))
+		if options.Urgency != "" {
+			renewed.Headers.Set("Urgency", options.Urgency)
+		}
+	}
+
+	if !msg.TokenExpired(c.clock.Now()) {
+		return &renewed, nil
+	}
+
+	endpoint, err := url.Parse(msg.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	identity, err := c.vapidIdentity(ctx, msg.info())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrVapidTokenExpired, err)
+	}
+
+	header, _, err := identity.header(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	renewed.Headers.Set("Authorization", header)
+	renewed.TokenExpires, _ = vapidExpiry(header)
+
+	return &renewed, nil
+}
+
+type vapidIdentity struct {
+	pair    *auth.VapidKeyPair
+	keys    VapidKeys
+	subject string
+	signer  auth.WebPushJwtSigner
+}
+
+func (id *vapidIdentity) header(endpoint *url.URL) (string, bool, error) {
+	if signer, ok := id.signer.(auth.CacheAwareJwtSigner); ok {
+		return signer.VapidHeaderCached(endpoint, id.pair, id.subject)
+	}
+
+	if signer, ok := id.signer.(auth.KeyPairJwtSigner); ok {
+		header, err := signer.VapidHeaderForKeyPair(endpoint, id.pair, id.subject)
+
+		return header, false, err
+	}
+
+	header, err := id.signer.VapidHeader(endpoint, id.keys.PrivateKey, id.keys.PublicKey, id.subject)
+
+	return header, false, err
+}
+
+func (c *WebPushClient) vapidIdentity(ctx context.Context, info *WebPushInfo) (*vapidIdentity, error) {
+	if info.TenantID != "" {
+		if c.tenants == nil {
+			return nil, ErrUnknownTenant
+		}
+
+		entry, err := c.tenants.entry(info.TenantID)
+		if err != nil {
+			return nil, err
+		}
+
+		return &vapidIdentity{
+			pair:    entry.pair,
+			keys:    entry.tenant.VapidDetails.VapidKeys,
+			subject: entry.tenant.VapidDetails.Subject,
+			signer:  entry.signer,
+		}, nil
+	}
+
+	details := info.VapidDetails
+	if details.PrivateKey == "" && c.keys != nil {
+		var err error
+		details, err = c.keys.VapidDetails(ctx, &info.Subscription)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if details.PrivateKey == "" {
+		return nil, errors.New("no vapid keys for message")
+	}
+
+	keyPair, err := c.keyPairs.get(&details.VapidKeys)
 	if err != nil {
 		return nil, err
 	}
 
-	return res, nil
+	return &vapidIdentity{
+		pair:    keyPair,
+		keys:    details.VapidKeys,
+		subject: details.Subject,
+		signer:  c.jwtSigner,
+	}, nil
 }
 
 func (c *WebPushClient) Send(payload []byte, info *WebPushInfo, options *WebPushOptions) (*http.Response, error) {
@@ -2,20 +2,31 @@ package webpush
 
 import (
 	"bytes"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"encoding/base64"
 	"io"
+	"math/big"
+	mathrand "math/rand"
 	"net/http"
+	"net/url"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/Firebain/webpush-go/auth"
+	"github.com/Firebain/webpush-go/clock"
 	"github.com/Firebain/webpush-go/ece"
 )
 
 type clientMock struct {
-	Called bool
+	Called  bool
+	Request *http.Request
 }
 
 func (c *clientMock) Do(req *http.Request) (*http.Response, error) {
 	c.Called = true
+	c.Request = req
 
 	return &http.Response{
 		StatusCode: 201,
@@ -54,3 +65,125 @@ func TestSendNotification(t *testing.T) {
 	}
 	res.Body.Close()
 }
+
+type legacySignerMock struct {
+	private string
+	public  string
+}
+
+func (s *legacySignerMock) VapidHeader(endpoint *url.URL, vapidPrivate, vapidPublic, subject string) (string, error) {
+	s.private = vapidPrivate
+	s.public = vapidPublic
+
+	return "vapid t=token, k=" + vapidPublic, nil
+}
+
+func TestSendWithLegacySigner(t *testing.T) {
+	info := testInfo()
+
+	client := clientMock{}
+	signer := legacySignerMock{}
+
+	webpush := NewWebPushClient(&client, &signer, &ece.Aes128GcmEncoder{})
+
+	if _, err := webpush.Send([]byte("Hello World!"), info, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if signer.private != info.VapidDetails.PrivateKey || signer.public != info.VapidDetails.PublicKey {
+		t.Fatal("Signer was not given the encoded keys")
+	}
+
+	if client.Request.Header.Get("Authorization") != "vapid t=token, k="+info.VapidDetails.PublicKey {
+		t.Fatal("Unexpected Authorization header", client.Request.Header.Get("Authorization"))
+	}
+}
+
+func TestSendReproducible(t *testing.T) {
+	info := WebPushInfo{
+		Subscription: Subscription{
+			Endpoint: "https://test-ns.com/ns/token",
+			Keys: SubscriptionKeys{
+				P256DH: "BFGGjgyqdoqg10kasOdjQ9M_XCGCUrHe9XdOtFtGgRQmxseX0rDCPnmkqUXK0sEhF30to0G4TonsvnxWq6BJrIA",
+				Auth:   "PVi3VfghXXXOELqDxy0oDA",
+			},
+		},
+		VapidDetails: VapidDetails{
+			Subject: "example@push.com",
+			VapidKeys: VapidKeys{
+				PrivateKey: "BdqJiVn-wHy0Jsr8kJ9kAceyuihPf31RiBP7SWtG5eU",
+				PublicKey:  "BC6EjsLzlGi7OaUSrB0MuURkbcdgq8XsTR3EwqwDhclzmh9xPCtpp50UCYgUV3IKwy3onLBhrtlWJktGzFapjGc",
+			},
+		},
+	}
+
+	send := func() (string, []byte) {
+		client := clientMock{}
+		jwtSigner := auth.SimpleJwtSigner{
+			Clock: clock.NewFake(time.Unix(1710588595, 0)),
+		}
+		encoder := ece.Aes128GcmEncoder{
+			Rand: mathrand.New(mathrand.NewSource(2)),
+		}
+
+		webpush := NewWebPushClient(&client, &jwtSigner, &encoder)
+
+		res, err := webpush.Send([]byte("Hello World!"), &info, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+
+		body, err := io.ReadAll(client.Request.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return client.Request.Header.Get("Authorization"), body
+	}
+
+	firstHeader, firstBody := send()
+	secondHeader, secondBody := send()
+
+	keyPair, err := info.VapidDetails.KeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verifyVapidHeader(t, firstHeader, keyPair)
+
+	if firstHeader != secondHeader {
+		t.Fatal("Authorization headers differ")
+	}
+
+	if !bytes.Equal(firstBody, secondBody) {
+		t.Fatal("Encrypted bodies differ")
+	}
+}
+
+func verifyVapidHeader(t *testing.T, header string, keyPair *auth.VapidKeyPair) {
+	t.Helper()
+
+	token, _, ok := strings.Cut(strings.TrimPrefix(header, "vapid t="), ",")
+	if !ok {
+		t.Fatal("Malformed Authorization header", header)
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		t.Fatal("Malformed JWT", token)
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	r := new(big.Int).SetBytes(sig[:32])
+	s := new(big.Int).SetBytes(sig[32:])
+
+	if !ecdsa.Verify(keyPair.ECDSAPublicKey(), hash[:], r, s) {
+		t.Fatal("Signature doesn't verify")
+	}
+}